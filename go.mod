@@ -7,6 +7,7 @@ require (
 	github.com/grafana/grafana-plugin-sdk-go v0.166.0
 	github.com/huaweicloud/huaweicloud-sdk-go-v3 v0.1.92
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/net v0.10.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -1,30 +1,150 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
-	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
 )
 
-var NsCache = NamespaceCache{MetaCache{
-	Data: sync.Map{},
-}}
+// MetaCaches 数据源实例的元数据缓存，不同数据源(账号)之间互相隔离，实例销毁时清空
+type MetaCaches struct {
+	Ns NamespaceCache
+	Dm DimensionCache
+	M  MetricCache
+	Ep MetaCache // 企业项目下的资源ID
+}
+
+const (
+	defaultNamespaceCacheTTL = 30 * time.Minute
+	defaultDimensionCacheTTL = 10 * time.Minute
+	defaultMetricCacheTTL    = 5 * time.Minute
+	enterpriseProjectTTL     = 10 * time.Minute
+	metaRetryInterval        = time.Minute
+)
+
+// CacheTTLs 各类元数据缓存的有效期，可在数据源配置中按秒设置；过期后仍返回旧数据并在后台刷新
+type CacheTTLs struct {
+	Namespace time.Duration
+	Dimension time.Duration
+	Metric    time.Duration
+}
+
+func newCacheTTLs(namespaceSeconds, dimensionSeconds, metricSeconds int) CacheTTLs {
+	ttls := CacheTTLs{Namespace: defaultNamespaceCacheTTL, Dimension: defaultDimensionCacheTTL, Metric: defaultMetricCacheTTL}
+	if namespaceSeconds > 0 {
+		ttls.Namespace = time.Duration(namespaceSeconds) * time.Second
+	}
+	if dimensionSeconds > 0 {
+		ttls.Dimension = time.Duration(dimensionSeconds) * time.Second
+	}
+	if metricSeconds > 0 {
+		ttls.Metric = time.Duration(metricSeconds) * time.Second
+	}
+	return ttls
+}
+
+func newMetaCaches(ttls CacheTTLs) *MetaCaches {
+	c := &MetaCaches{}
+	c.Ns.ttl = ttls.Namespace.Milliseconds()
+	c.Dm.ttl = ttls.Dimension.Milliseconds()
+	c.M.ttl = ttls.Metric.Milliseconds()
+	c.Ep.ttl = enterpriseProjectTTL.Milliseconds()
+	return c
+}
+
+func (c *MetaCaches) getMetaUtil(param *QueryParam) MetaUtil {
+	if param.Namespace == "" && param.DimStr == "" {
+		return &c.Ns
+	}
 
-var DmCache = DimensionCache{MetaCache{
-	Data: sync.Map{},
-}}
+	if param.DimStr != "" {
+		return &c.M
+	}
 
-var MCache = MetricCache{MetaCache{
-	Data: sync.Map{},
-}}
+	return &c.Dm
+}
 
 type MetaCache struct {
-	Data sync.Map // key: string, value: CachedMeta
+	Data    sync.Map // key: string, value: CachedMeta
+	ttl     int64    // 毫秒
+	flights flightGroup
 }
 
+// flightGroup 合并相同key的并发查询，所有等待者都取消后才取消查询，后台刷新不会被取消
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	waiters    int
+	background bool
+	done       chan struct{}
+	res        []string
+	err        error
+}
+
+// start 需持有锁调用，key已有进行中的查询时直接返回该查询
+func (g *flightGroup) start(key string, fetch func(context.Context) ([]string, error)) *flightCall {
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok && call.ctx.Err() == nil {
+		return call
+	}
+	fetchCtx, cancel := context.WithCancel(context.Background())
+	call := &flightCall{ctx: fetchCtx, cancel: cancel, done: make(chan struct{})}
+	g.calls[key] = call
+	go func() {
+		call.res, call.err = func() (res []string, err error) {
+			defer recoverPanic(&err)
+			return fetch(fetchCtx)
+		}()
+		g.mu.Lock()
+		if g.calls[key] == call {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		cancel()
+		close(call.done)
+	}()
+	return call
+}
+
+func (g *flightGroup) do(ctx context.Context, key string, fetch func(context.Context) ([]string, error)) ([]string, error) {
+	g.mu.Lock()
+	call := g.start(key, fetch)
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.res, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 && !call.background {
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// refresh 在后台执行查询，不等待结果
+func (g *flightGroup) refresh(key string, fetch func(context.Context) ([]string, error)) {
+	g.mu.Lock()
+	g.start(key, fetch).background = true
+	g.mu.Unlock()
+}
+
+// CachedMeta 写入缓存后不再修改，更新时写入新的条目(copy-on-write)，读取方不能修改Meta
 type CachedMeta struct {
 	Name       string
 	Meta       []string
@@ -32,12 +152,24 @@ type CachedMeta struct {
 	Marker     string
 	TTL        int64
 	UpdateTime int64
+	// 已查完的条目过期后刷新的进度，刷新查完前仍返回Meta
+	Refreshing    []string
+	RefreshMarker string
 }
 
 func (cache *CachedMeta) isExpired() bool {
 	return cache.UpdateTime+cache.TTL < getTimestamp()
 }
 
+// retryLater 查询失败后间隔metaRetryInterval再刷新，避免流控或故障时每个请求都重新查询；有进展时下次请求直接继续
+func (cache *CachedMeta) retryLater(progressed bool) {
+	if progressed {
+		cache.UpdateTime = 0
+		return
+	}
+	cache.UpdateTime = getTimestamp() - cache.TTL + metaRetryInterval.Milliseconds()
+}
+
 func (c *MetaCache) getCachedMeta(key string) *CachedMeta {
 	if v, ok := c.Data.Load(key); ok {
 		if cache, ok := v.(*CachedMeta); ok {
@@ -47,11 +179,48 @@ func (c *MetaCache) getCachedMeta(key string) *CachedMeta {
 	return nil
 }
 
-func resetCache() {
-	NsCache.Data = sync.Map{}
-	DmCache.Data = sync.Map{}
-	MCache.Data = sync.Map{}
-	runtime.GC()
+func (c *MetaCache) clear() {
+	c.Data.Range(func(key, value interface{}) bool {
+		c.Data.Delete(key)
+		return true
+	})
+}
+
+func (c *MetaCaches) reset() {
+	c.Ns.clear()
+	c.Dm.clear()
+	c.M.clear()
+	c.Ep.clear()
+}
+
+// purge 删除key中region/namespace匹配的缓存，参数为空表示不限；key格式为"目标账号|region|..."，
+// nsIndex为namespace在key中的位置，小于0表示key不含namespace，指定namespace时不删除
+func (c *MetaCache) purge(region, namespace string, nsIndex int) int {
+	if namespace != "" && nsIndex < 0 {
+		return 0
+	}
+	count := 0
+	c.Data.Range(func(key, value interface{}) bool {
+		parts := strings.Split(key.(string), "|")
+		if region != "" && (len(parts) < 2 || parts[1] != region) {
+			return true
+		}
+		if namespace != "" && (len(parts) <= nsIndex || parts[nsIndex] != namespace) {
+			return true
+		}
+		c.Data.Delete(key)
+		count++
+		return true
+	})
+	return count
+}
+
+// purge 按region/namespace清理缓存，返回删除的缓存条数
+func (c *MetaCaches) purge(region, namespace string) int {
+	return c.Ns.purge(region, namespace, -1) +
+		c.Dm.purge(region, namespace, 2) +
+		c.M.purge(region, namespace, 2) +
+		c.Ep.purge(region, namespace, 3)
 }
 
 type MetaUtil interface {
@@ -68,11 +237,11 @@ type NamespaceCache struct {
 }
 
 func (c *NamespaceCache) newCachedMeta() *CachedMeta {
-	return &CachedMeta{TTL: 30 * 60 * 1000}
+	return &CachedMeta{TTL: c.ttl}
 }
 
 func (c *NamespaceCache) getCache() *MetaCache {
-	return &(NsCache.MetaCache)
+	return &c.MetaCache
 }
 
 func (c *NamespaceCache) buildKey(params *QueryParam) string {
@@ -96,11 +265,11 @@ type DimensionCache struct {
 }
 
 func (c *DimensionCache) newCachedMeta() *CachedMeta {
-	return &CachedMeta{TTL: 10 * 60 * 1000}
+	return &CachedMeta{TTL: c.ttl}
 }
 
 func (c *DimensionCache) getCache() *MetaCache {
-	return &(DmCache.MetaCache)
+	return &c.MetaCache
 }
 
 func (c *DimensionCache) buildKey(param *QueryParam) string {
@@ -126,11 +295,11 @@ type MetricCache struct {
 }
 
 func (c *MetricCache) newCachedMeta() *CachedMeta {
-	return &CachedMeta{TTL: 5 * 60 * 1000}
+	return &CachedMeta{TTL: c.ttl}
 }
 
 func (c *MetricCache) getCache() *MetaCache {
-	return &(MCache.MetaCache)
+	return &c.MetaCache
 }
 
 func (c *MetricCache) buildKey(param *QueryParam) string {
@@ -147,7 +316,7 @@ func (c *MetricCache) buildQuery(param *QueryParam) *model.ListMetricsRequest {
 		Namespace: &param.Namespace,
 	}
 	for i, dim := range dims {
-		dims[i]=strings.ReplaceAll(dim,":",",")
+		dims[i] = strings.ReplaceAll(dim, ":", ",")
 	}
 	switch len(dims) {
 	case 3:
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCES 按marker分页返回namespace列表，marker为下一页的起始下标
+type fakeCES struct {
+	mu       sync.Mutex
+	metrics  []model.MetricInfoList
+	pageSize int
+	delay    time.Duration
+	err      error
+	calls    int // ListMetrics调用次数
+	pagers   int // 从第一页开始的分页查询次数
+}
+
+func newFakeCES(total, pageSize int) *fakeCES {
+	f := &fakeCES{pageSize: pageSize}
+	for i := 0; i < total; i++ {
+		f.metrics = append(f.metrics, model.MetricInfoList{Namespace: fmt.Sprintf("SYS.NS%d", i)})
+	}
+	return f
+}
+
+func (f *fakeCES) ListMetrics(req *model.ListMetricsRequest) (*model.ListMetricsResponse, error) {
+	f.mu.Lock()
+	f.calls++
+	if req.Start == nil {
+		f.pagers++
+	}
+	err := f.err
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if req.Start != nil {
+		start, _ = strconv.Atoi(*req.Start)
+	}
+	end := start + f.pageSize
+	if end > len(f.metrics) {
+		end = len(f.metrics)
+	}
+	page := append([]model.MetricInfoList{}, f.metrics[start:end]...)
+	return &model.ListMetricsResponse{
+		Metrics:  &page,
+		MetaData: &model.MetaData{Count: int32(len(page)), Total: int32(len(f.metrics)), Marker: strconv.Itoa(end)},
+	}, nil
+}
+
+func (f *fakeCES) BatchListMetricData(*model.BatchListMetricDataRequest) (*model.BatchListMetricDataResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeCES) ListAlarms(*model.ListAlarmsRequest) (*model.ListAlarmsResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeCES) stats() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls, f.pagers
+}
+
+func (f *fakeCES) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+const testNsKey = "|cn-north-4"
+
+func newTestCESClient(f *fakeCES, timeout time.Duration) *CESClient {
+	return &CESClient{
+		Client:   f,
+		Region:   "cn-north-4",
+		Timeouts: Timeouts{Query: timeout, Meta: timeout, Check: timeout},
+		Caches:   newMetaCaches(newCacheTTLs(0, 0, 0)),
+	}
+}
+
+func namespaces(from, to int) []string {
+	var res []string
+	for i := from; i < to; i++ {
+		res = append(res, fmt.Sprintf("SYS.NS%d", i))
+	}
+	return res
+}
+
+// listConcurrently 并发调用ListMeta，返回各次调用的结果
+func listConcurrently(c *CESClient, n int) [][]string {
+	results := make([][]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.ListMeta(context.Background(), &QueryParam{})
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func waitForCache(t *testing.T, c *CESClient, cond func(*CachedMeta) bool) *CachedMeta {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cached := c.Caches.Ns.getCachedMeta(testNsKey); cached != nil && cond(cached) {
+			return cached
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("cache condition not met before deadline")
+	return nil
+}
+
+func TestListMetaCoalescesConcurrentRequests(t *testing.T) {
+	f := newFakeCES(60, 10)
+	f.delay = 5 * time.Millisecond
+	c := newTestCESClient(f, 5*time.Second)
+
+	for _, res := range listConcurrently(c, 20) {
+		assert.Equal(t, namespaces(0, 60), res)
+	}
+	calls, pagers := f.stats()
+	assert.Equal(t, 1, pagers)
+	assert.Equal(t, 7, calls)
+
+	cached := c.Caches.Ns.getCachedMeta(testNsKey)
+	assert.True(t, cached.Finished)
+	assert.False(t, cached.isExpired())
+}
+
+func TestListMetaResumesUnfinishedEntry(t *testing.T) {
+	f := newFakeCES(60, 10)
+	c := newTestCESClient(f, 5*time.Second)
+	c.Caches.Ns.Data.Store(testNsKey, &CachedMeta{Meta: namespaces(0, 20), Marker: "20", TTL: c.Caches.Ns.ttl})
+
+	// 未查完的部分结果直接返回，后台从marker继续查询，查完后返回完整结果
+	for _, res := range listConcurrently(c, 20) {
+		assert.Contains(t, [][]string{namespaces(0, 20), namespaces(0, 60)}, res)
+	}
+	cached := waitForCache(t, c, func(cached *CachedMeta) bool { return cached.Finished })
+	assert.Equal(t, namespaces(0, 60), cached.Meta)
+	_, pagers := f.stats()
+	assert.Equal(t, 0, pagers)
+}
+
+func TestListMetaServesExpiredEntryWhileRefreshing(t *testing.T) {
+	f := newFakeCES(60, 10)
+	f.delay = 5 * time.Millisecond
+	c := newTestCESClient(f, 5*time.Second)
+	c.Caches.Ns.Data.Store(testNsKey, &CachedMeta{Meta: []string{"SYS.OLD"}, Finished: true, TTL: c.Caches.Ns.ttl})
+
+	// 刷新完成前返回旧数据
+	for _, res := range listConcurrently(c, 20) {
+		assert.Contains(t, [][]string{{"SYS.OLD"}, namespaces(0, 60)}, res)
+	}
+	cached := waitForCache(t, c, func(cached *CachedMeta) bool { return !cached.isExpired() })
+	assert.Equal(t, namespaces(0, 60), cached.Meta)
+	_, pagers := f.stats()
+	assert.Equal(t, 1, pagers)
+}
+
+func TestListMetaContinuesRefreshAcrossTimeouts(t *testing.T) {
+	f := newFakeCES(60, 10)
+	f.delay = 20 * time.Millisecond
+	c := newTestCESClient(f, 50*time.Millisecond)
+	c.Caches.Ns.Data.Store(testNsKey, &CachedMeta{Meta: []string{"SYS.OLD"}, Finished: true, TTL: c.Caches.Ns.ttl})
+
+	// 刷新超时前一直返回旧数据，后续请求从保存的marker继续刷新
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		res := listConcurrently(c, 5)[0]
+		if len(res) == 60 {
+			break
+		}
+		assert.Equal(t, []string{"SYS.OLD"}, res)
+		time.Sleep(10 * time.Millisecond)
+	}
+	cached := waitForCache(t, c, func(cached *CachedMeta) bool { return !cached.isExpired() })
+	assert.Equal(t, namespaces(0, 60), cached.Meta)
+	assert.Nil(t, cached.Refreshing)
+	_, pagers := f.stats()
+	assert.Equal(t, 1, pagers)
+}
+
+func TestListMetaKeepsStaleEntryOnRefreshFailure(t *testing.T) {
+	f := newFakeCES(60, 10)
+	f.setErr(errors.New("throttled"))
+	c := newTestCESClient(f, 5*time.Second)
+	c.Caches.Ns.Data.Store(testNsKey, &CachedMeta{Meta: []string{"SYS.OLD"}, Finished: true, TTL: c.Caches.Ns.ttl})
+
+	assert.Equal(t, []string{"SYS.OLD"}, c.ListMeta(context.Background(), &QueryParam{}))
+	cached := waitForCache(t, c, func(cached *CachedMeta) bool { return !cached.isExpired() })
+	assert.Equal(t, []string{"SYS.OLD"}, cached.Meta)
+
+	// 刷新失败后在重试间隔内不再请求CES
+	for _, res := range listConcurrently(c, 20) {
+		assert.Equal(t, []string{"SYS.OLD"}, res)
+	}
+	calls, _ := f.stats()
+	assert.Equal(t, 1, calls)
+}
+
+func TestListMetaWaiterBoundedByOwnContext(t *testing.T) {
+	f := newFakeCES(60, 10)
+	f.delay = 50 * time.Millisecond
+	c := newTestCESClient(f, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, namespaces(0, 60), c.ListMeta(context.Background(), &QueryParam{}))
+	}()
+	// 等待第一个请求开始分页查询
+	time.Sleep(5 * time.Millisecond)
+	start := time.Now()
+	assert.Nil(t, c.ListMeta(ctx, &QueryParam{}))
+	assert.Less(t, time.Since(start), 40*time.Millisecond)
+	wg.Wait()
+
+	_, pagers := f.stats()
+	assert.Equal(t, 1, pagers)
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
+)
+
+// MetricCatalogItem 指标展示信息，Unit为Grafana单位ID
+type MetricCatalogItem struct {
+	Unit        string `yaml:"unit"`
+	DisplayName string `yaml:"displayName"`
+	Description string `yaml:"description"`
+}
+
+// 内置指标目录，key: namespace|metricName，可通过metric.yaml的metricCatalog覆盖或扩展
+var defaultMetricCatalog = map[string]MetricCatalogItem{
+	"SYS.ECS|cpu_util":                              {Unit: "percent", DisplayName: "CPU使用率"},
+	"SYS.ECS|mem_util":                              {Unit: "percent", DisplayName: "内存使用率"},
+	"SYS.ECS|disk_util_inband":                      {Unit: "percent", DisplayName: "磁盘使用率"},
+	"SYS.ECS|disk_read_bytes_rate":                  {Unit: "Bps", DisplayName: "磁盘读带宽"},
+	"SYS.ECS|disk_write_bytes_rate":                 {Unit: "Bps", DisplayName: "磁盘写带宽"},
+	"SYS.ECS|disk_read_requests_rate":               {Unit: "iops", DisplayName: "磁盘读IOPS"},
+	"SYS.ECS|disk_write_requests_rate":              {Unit: "iops", DisplayName: "磁盘写IOPS"},
+	"SYS.ECS|network_incoming_bytes_rate_inband":    {Unit: "Bps", DisplayName: "带内网络流入速率"},
+	"SYS.ECS|network_outgoing_bytes_rate_inband":    {Unit: "Bps", DisplayName: "带内网络流出速率"},
+	"SYS.ECS|network_incoming_bytes_aggregate_rate": {Unit: "Bps", DisplayName: "带外网络流入速率"},
+	"SYS.ECS|network_outgoing_bytes_aggregate_rate": {Unit: "Bps", DisplayName: "带外网络流出速率"},
+	"SYS.ECS|network_vm_pps_in":                     {Unit: "pps", DisplayName: "虚拟机入方向PPS"},
+	"SYS.ECS|network_vm_pps_out":                    {Unit: "pps", DisplayName: "虚拟机出方向PPS"},
+	"SYS.ECS|network_vm_connections":                {Unit: "short", DisplayName: "虚拟机整机连接数"},
+	"SYS.ELB|m1_cps":                                {Unit: "short", DisplayName: "并发连接数"},
+	"SYS.ELB|m2_act_conn":                           {Unit: "short", DisplayName: "活跃连接数"},
+	"SYS.ELB|m3_inact_conn":                         {Unit: "short", DisplayName: "非活跃连接数"},
+	"SYS.ELB|m4_ncps":                               {Unit: "cps", DisplayName: "新建连接数"},
+	"SYS.ELB|m5_in_pps":                             {Unit: "pps", DisplayName: "流入数据包数"},
+	"SYS.ELB|m6_out_pps":                            {Unit: "pps", DisplayName: "流出数据包数"},
+	"SYS.ELB|m7_in_Bps":                             {Unit: "Bps", DisplayName: "网络流入速率"},
+	"SYS.ELB|m8_out_Bps":                            {Unit: "Bps", DisplayName: "网络流出速率"},
+	"SYS.ELB|m9_abnormal_servers":                   {Unit: "short", DisplayName: "异常主机数"},
+	"SYS.ELB|ma_normal_servers":                     {Unit: "short", DisplayName: "正常主机数"},
+	"SYS.RDS|rds001_cpu_util":                       {Unit: "percent", DisplayName: "CPU使用率"},
+	"SYS.RDS|rds002_mem_util":                       {Unit: "percent", DisplayName: "内存使用率"},
+	"SYS.RDS|rds003_iops":                           {Unit: "iops", DisplayName: "IOPS"},
+	"SYS.DCS|cpu_usage":                             {Unit: "percent", DisplayName: "CPU使用率"},
+	"SYS.DCS|memory_usage":                          {Unit: "percent", DisplayName: "内存使用率"},
+	"SYS.DCS|bandwidth_usage":                       {Unit: "percent", DisplayName: "带宽使用率"},
+	"SYS.DCS|instantaneous_ops":                     {Unit: "ops", DisplayName: "每秒并发操作数"},
+	"SYS.DCS|keys":                                  {Unit: "short", DisplayName: "缓存键总数"},
+	"SYS.DCS|rx_controlled":                         {Unit: "short", DisplayName: "流控次数"},
+	"SYS.DCS|is_slow_log_exist":                     {Unit: "bool", DisplayName: "是否存在慢日志"},
+}
+
+// cesUnits CES返回的单位到Grafana单位ID的映射，指标不在目录中时使用
+var cesUnits = map[string]string{
+	"%":       "percent",
+	"Byte":    "decbytes",
+	"Bytes":   "decbytes",
+	"Byte/s":  "Bps",
+	"bit/s":   "bps",
+	"Count":   "short",
+	"Count/s": "cps",
+	"ms":      "ms",
+	"s":       "s",
+}
+
+// GetMetricCatalogItem metric.yaml配置优先，其次为内置目录
+func GetMetricCatalogItem(namespace, metricName string) (MetricCatalogItem, bool) {
+	key := fmt.Sprintf("%s|%s", namespace, metricName)
+	if item, ok := GetMeta().MetricCatalog[key]; ok {
+		return item, true
+	}
+	item, ok := defaultMetricCatalog[key]
+	return item, ok
+}
+
+// buildFieldConfig 根据指标目录和CES返回的单位生成数值字段的展示配置
+func buildFieldConfig(namespace, metricName, filter string, dims []model.MetricsDimension, cesUnit *string) *data.FieldConfig {
+	item, _ := GetMetricCatalogItem(namespace, metricName)
+	if item.Unit == "" && cesUnit != nil {
+		item.Unit = cesUnits[*cesUnit]
+	}
+	if item.Unit == "" && item.DisplayName == "" && item.Description == "" {
+		return nil
+	}
+
+	config := &data.FieldConfig{
+		Unit:        item.Unit,
+		Description: item.Description,
+	}
+	// 同一面板可能有多个资源、多个统计值的同名指标，展示名带上维度和统计值以便区分
+	if item.DisplayName != "" {
+		config.DisplayNameFromDS = fmt.Sprintf("%s(%s) %s", item.DisplayName, getDimStr(dims), filter)
+	}
+	return config
+}
@@ -1,131 +1,574 @@
 package plugin
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/basic"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/config"
+	coreRegion "github.com/huaweicloud/huaweicloud-sdk-go-v3/core/region"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/sdkerr"
 	ces "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/region"
+	eps "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/eps/v1"
+	"golang.org/x/net/http/httpproxy"
 )
 
-func GetCESClient(c *CloudEyeSettings) *ces.CesClient {
+// newHttpTransport 默认校验服务端证书，可配置自定义CA及客户端证书用于私有化endpoint
+func newHttpTransport(c *CloudEyeSettings) (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.TLSSkipVerify}
+	if c.TLSCACert != "" {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(c.TLSCACert)) {
+			return nil, errors.New("invalid TLS CA certificate")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if c.TLSClientCert != "" || c.TLSClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(c.TLSClientCert), []byte(c.TLSClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	proxy, err := proxyFunc(c)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           proxy,
+	}, nil
+}
+
+// proxyFunc 配置了代理时使用配置的代理，否则读取HTTPS_PROXY/HTTP_PROXY环境变量；两种方式均遵循NO_PROXY环境变量
+func proxyFunc(c *CloudEyeSettings) (func(*http.Request) (*url.URL, error), error) {
+	if c.ProxyHost == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	// Proxy Host可带scheme，如https://proxy.example.com:3128，未带scheme时使用http
+	proxyURL := &url.URL{Scheme: "http", Host: c.ProxyHost}
+	if strings.Contains(c.ProxyHost, "://") {
+		parsed, err := url.Parse(c.ProxyHost)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid proxy host: %s", c.ProxyHost)
+		}
+		proxyURL = &url.URL{Scheme: parsed.Scheme, Host: parsed.Host, User: parsed.User}
+	}
+	if c.ProxyPort != 0 {
+		proxyURL.Host = net.JoinHostPort(proxyURL.Hostname(), strconv.Itoa(c.ProxyPort))
+	}
+	if c.ProxyUsername != "" {
+		proxyURL.User = url.UserPassword(c.ProxyUsername, c.ProxyPassword)
+	}
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  proxyURL.String(),
+		HTTPSProxy: proxyURL.String(),
+		NoProxy:    httpproxy.FromEnvironment().NoProxy,
+	}
+	proxy := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// buildCESClient 同一数据源的客户端共用transport以复用连接，regionName为查询的region
+func buildCESClient(c *CloudEyeSettings, regionName string, transport *http.Transport) (*ces.CesClient, error) {
 	creBuilder := basic.NewCredentialsBuilder().
 		WithAk(c.AK).
 		WithSk(c.SK)
+	if c.SecurityToken != "" {
+		creBuilder = creBuilder.WithSecurityToken(c.SecurityToken)
+	}
 
-	httpConfig := config.DefaultHttpConfig().WithIgnoreSSLVerification(true)
-	// 单region模式
-	if c.ProjectID != "" && c.CESEndpoint != "" {
-		creBuilder = creBuilder.WithProjectId(c.ProjectID)
+	// 请求取消后SDK调用仍在后台执行，以最长的操作超时时间作为单次HTTP请求的超时时间
+	httpConfig := config.DefaultHttpConfig().WithHttpTransport(transport).WithTimeout(c.Timeouts.max())
+	// 单region模式，可配置多个region/project；未配置的region不能回退到SDK的公有云region，避免凭证发往公有云
+	if len(c.specificRegions()) > 0 {
+		entry, ok := c.regionEndpoint(regionName)
+		if !ok {
+			return nil, fmt.Errorf("region %s is not configured", regionName)
+		}
+		cred, err := creBuilder.WithProjectId(entry.ProjectID).SafeBuild()
+		if err != nil {
+			return nil, err
+		}
 		clientBuilder := ces.CesClientBuilder().
-			WithCredential(creBuilder.Build()).
+			WithCredential(cred).
 			WithHttpConfig(httpConfig).
-			WithEndpoint(c.CESEndpoint)
-		return ces.NewCesClient(clientBuilder.Build())
+			WithEndpoint(entry.CESEndpoint)
+		return newCESClient(clientBuilder)
 	}
 
-	// 多region模式，只支持华为云, region列表依赖SDK
+	// 多region模式，region列表依赖SDK，可通过endpoint配置覆盖或扩展
+	cesRegion, err := resolveRegion(c, regionName)
+	if err != nil {
+		return nil, err
+	}
+	// 自动获取project id时使用配置的IAM endpoint
+	if c.IAMEndpoint != "" {
+		creBuilder = creBuilder.WithIamEndpointOverride(c.IAMEndpoint)
+	}
+	cred, err := creBuilder.SafeBuild()
+	if err != nil {
+		return nil, err
+	}
 	clientBuilder := ces.CesClientBuilder().
-		WithCredential(creBuilder.Build()).
+		WithCredential(cred).
 		WithHttpConfig(httpConfig).
-		WithRegion(region.ValueOf(c.Region))
-	return ces.NewCesClient(clientBuilder.Build())
+		WithRegion(cesRegion)
+	return newCESClient(clientBuilder)
+}
+
+func newCESClient(builder *core.HcHttpClientBuilder) (*ces.CesClient, error) {
+	hcClient, err := builder.SafeBuild()
+	if err != nil {
+		return nil, err
+	}
+	return ces.NewCesClient(hcClient), nil
+}
+
+// resolveRegion 数据源配置的endpoint优先，其次为metric.yaml的endpoints配置，最后使用SDK内置的region列表
+func resolveRegion(c *CloudEyeSettings, regionName string) (*coreRegion.Region, error) {
+	if regionName == "" {
+		return nil, errors.New("region is required")
+	}
+	if endpoint, ok := c.CESEndpoints[regionName]; ok && endpoint != "" {
+		return coreRegion.NewRegion(regionName, endpoint), nil
+	}
+	if endpoint, ok := GetMeta().Endpoints[regionName]; ok && endpoint != "" {
+		return coreRegion.NewRegion(regionName, endpoint), nil
+	}
+	cesRegion, err := region.SafeValueOf(regionName)
+	if err != nil {
+		return nil, fmt.Errorf("unknown region %s, please configure its CES endpoint", regionName)
+	}
+	return cesRegion, nil
+}
+
+// cesAPI CESClient依赖的CES接口，由*ces.CesClient实现，可替换为其他实现
+type cesAPI interface {
+	BatchListMetricData(request *model.BatchListMetricDataRequest) (*model.BatchListMetricDataResponse, error)
+	ListMetrics(request *model.ListMetricsRequest) (*model.ListMetricsResponse, error)
+	ListAlarms(request *model.ListAlarmsRequest) (*model.ListAlarmsResponse, error)
 }
 
 type CESClient struct {
-	Client *ces.CesClient
-	Region string
+	Client   cesAPI
+	Region   string
+	Domain   string // 通过委托访问的目标账号，为空表示数据源默认账号
+	Timeouts Timeouts
+	Caches   *MetaCaches // 所属数据源实例的元数据缓存
+
+	buildEPS func() (*eps.EpsClient, error)
+	epsOnce  sync.Once
+	eps      *eps.EpsClient
+	epsErr   error
 }
 
 type DataQueryParam struct {
-	Region     string
-	Namespace  string
-	DimStr     string
-	MetricName string
-	Filter     string
-	Period     string
-	From       int64
-	To         int64
-	RefID      string
-}
-
-func getValueByFilter(v model.DatapointForBatchMetric, filter string) float64 {
+	Region      string   `json:"region"`
+	Namespace   string   `json:"namespace"`
+	DimStr      string   `json:"dimstr"`
+	MetricName  string   `json:"metricName"`
+	Filter      string   `json:"filter"`
+	Period      string   `json:"period"`
+	Stats       []string `json:"stats"` // 同时查询多个统计值，非空时忽略Filter
+	FillGaps    bool     `json:"fillGaps"`
+	AlignPeriod bool     `json:"alignPeriod"`
+	// 跨账号查询的目标账号，为空时使用数据源配置
+	AgencyDomain string `json:"agencyDomain"`
+	// 只查询企业项目内的资源
+	EnterpriseProjectID string `json:"enterpriseProjectId"`
+	From                int64  `json:"-"`
+	To                  int64  `json:"-"`
+	RefID               string `json:"-"`
+}
+
+// getValueByFilter 数据点缺少对应统计值时返回nil，在frame中表示为空值
+func getValueByFilter(v model.DatapointForBatchMetric, filter string) *float64 {
 	switch filter {
 	case "average":
-		return *(v.Average)
+		return v.Average
 	case "min":
-		return *(v.Min)
+		return v.Min
 	case "max":
-		return *(v.Max)
+		return v.Max
 	case "sum":
-		return *(v.Sum)
+		return v.Sum
+	case "variance":
+		return v.Variance
 	}
-	return 0
+	return nil
 }
 
-func (c *CESClient) BatchQuery(refIDs []string, req *model.BatchListMetricDataRequest) (*backend.QueryDataResponse, error) {
-	res, err := c.Client.BatchListMetricData(req)
-	if err != nil {
-		return nil, err
-	}
+// FrameOptions 控制监控数据转换为frame的方式
+type FrameOptions struct {
+	FillGaps    bool // 数据点缺失时按period补充空值
+	AlignPeriod bool // 数据点时间对齐到period边界，便于不同指标join/计算
+	StatLabel   bool // 多统计值查询时添加stat标签区分各统计值
+}
 
-	response := backend.NewQueryDataResponse()
-	for i, each := range *(res.Metrics) {
-		timeDuration := make([]time.Time, 0, len(each.Datapoints))
-		values := make([]float64, 0, len(each.Datapoints))
+// alignPoints 将时间戳向下对齐到period边界，同一周期内有多个点时保留最后一个
+func alignPoints(times []time.Time, values []*float64, period time.Duration) ([]time.Time, []*float64) {
+	if period <= time.Second || len(times) == 0 {
+		return times, values
+	}
+	alignedTimes := make([]time.Time, 0, len(times))
+	alignedValues := make([]*float64, 0, len(values))
+	for i := range times {
+		t := times[i].Truncate(period)
+		if n := len(alignedTimes); n > 0 && alignedTimes[n-1].Equal(t) {
+			alignedValues[n-1] = values[i]
+			continue
+		}
+		alignedTimes = append(alignedTimes, t)
+		alignedValues = append(alignedValues, values[i])
+	}
+	return alignedTimes, alignedValues
+}
 
-		for _, v := range each.Datapoints {
-			timeDuration = append(timeDuration, time.Unix(v.Timestamp/1000, 0))
-			values = append(values, getValueByFilter(v, req.Body.Filter))
+// fillGaps 相邻数据点间隔超过period时按period补充空值，使Grafana断开连线；
+// 原始粒度的上报时间有抖动，间隔超过1.5倍period才视为缺失
+func fillGaps(times []time.Time, values []*float64, period time.Duration) ([]time.Time, []*float64) {
+	if period <= 0 || len(times) < 2 {
+		return times, values
+	}
+	filledTimes := make([]time.Time, 0, len(times))
+	filledValues := make([]*float64, 0, len(values))
+	for i := range times {
+		if i > 0 && times[i].Sub(times[i-1]) > period*3/2 {
+			for t := times[i-1].Add(period); times[i].Sub(t) >= period/2; t = t.Add(period) {
+				filledTimes = append(filledTimes, t)
+				filledValues = append(filledValues, nil)
+			}
 		}
+		filledTimes = append(filledTimes, times[i])
+		filledValues = append(filledValues, values[i])
+	}
+	return filledTimes, filledValues
+}
+
+const (
+	// BatchListMetricData单次请求的指标数上限
+	maxBatchMetrics = 500
+	// 分批查询的最大并发数
+	maxBatchWorkers = 5
+)
+
+// 命名空间格式为"服务.资源"，如SYS.ECS、AGT.ECS
+var namespacePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*\.[A-Za-z0-9_]+$`)
 
-		yLabel := map[string]string{
-			"namespace": *each.Namespace,
+func validateMetric(metric model.MetricInfo) error {
+	if !namespacePattern.MatchString(metric.Namespace) {
+		return fmt.Errorf("invalid namespace: %s", metric.Namespace)
+	}
+	if metric.MetricName == "" {
+		return errors.New("metric_name is required")
+	}
+	if len(metric.Dimensions) == 0 || len(metric.Dimensions) > 4 {
+		return fmt.Errorf("invalid dimensions count: %d", len(metric.Dimensions))
+	}
+	for _, dim := range metric.Dimensions {
+		if dim.Name == "" || dim.Value == "" {
+			return fmt.Errorf("invalid dimension: %s:%s", dim.Name, dim.Value)
 		}
-		for _, dim := range *each.Dimensions {
-			yLabel[dim.Name] = dim.Value
+	}
+	return nil
+}
+
+func isBadRequest(err error) bool {
+	var respErr *sdkerr.ServiceResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest
+}
+
+func errDataResponse(err error) backend.DataResponse {
+	var respErr *sdkerr.ServiceResponseError
+	if errors.Is(err, context.DeadlineExceeded) {
+		return backend.ErrDataResponse(backend.StatusTimeout, err.Error())
+	}
+	if errors.As(err, &respErr) {
+		return backend.ErrDataResponse(backend.Status(respErr.StatusCode), err.Error())
+	}
+	return backend.ErrDataResponse(backend.StatusInternal, err.Error())
+}
+
+// BatchQuery 批量查询监控数据，单个指标的错误只返回给对应的refID，不影响同批次的其他指标；
+// 请求取消或超时后不再发起新的分批请求
+func (c *CESClient) BatchQuery(ctx context.Context, refIDs []string, req *model.BatchListMetricDataRequest, opts FrameOptions) (*backend.QueryDataResponse, error) {
+	if req == nil || req.Body == nil || len(refIDs) != len(req.Body.Metrics) {
+		return nil, errors.New("invalid batch query params")
+	}
+
+	response := backend.NewQueryDataResponse()
+	validRefIDs := make([]string, 0, len(refIDs))
+	validMetrics := make([]model.MetricInfo, 0, len(refIDs))
+	for i, metric := range req.Body.Metrics {
+		if err := validateMetric(metric); err != nil {
+			response.Responses[refIDs[i]] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
 		}
+		validRefIDs = append(validRefIDs, refIDs[i])
+		validMetrics = append(validMetrics, metric)
+	}
+	if len(validMetrics) == 0 {
+		return response, nil
+	}
 
-		fields := []*data.Field{
-			data.NewField("time", nil, timeDuration),
-			data.NewField(each.MetricName, yLabel, values),
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Query)
+	defer cancel()
+	// 超过单次请求指标数上限时分批并发查询
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	workers := make(chan struct{}, maxBatchWorkers)
+	for start := 0; start < len(validMetrics); start += maxBatchMetrics {
+		end := start + maxBatchMetrics
+		if end > len(validMetrics) {
+			end = len(validMetrics)
 		}
-		frame := data.NewFrame("")
-		frame.Fields = append(frame.Fields, fields...)
-		eachRes := backend.DataResponse{}
-		eachRes.Frames = append(eachRes.Frames, frame)
-		response.Responses[refIDs[i]] = eachRes
+		body := *req.Body
+		body.Metrics = validMetrics[start:end]
+		chunkRefIDs := validRefIDs[start:end]
+
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			chunkRes := backend.NewQueryDataResponse()
+			err := func() (err error) {
+				defer recoverPanic(&err)
+				c.batchQuery(ctx, chunkRefIDs, &body, opts, chunkRes)
+				return nil
+			}()
+			// panic时未返回结果的指标返回错误
+			if err != nil {
+				for _, refID := range chunkRefIDs {
+					if _, ok := chunkRes.Responses[refID]; !ok {
+						chunkRes.Responses[refID] = errDataResponse(err)
+					}
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for refID, eachRes := range chunkRes.Responses {
+				response.Responses[refID] = eachRes
+			}
+		}()
 	}
+	wg.Wait()
 	return response, nil
 }
 
+// batchQuery 整批请求因参数错误失败时，二分后重试，直到定位出错的指标
+func (c *CESClient) batchQuery(ctx context.Context, refIDs []string, body *model.BatchListMetricDataRequestBody, opts FrameOptions, response *backend.QueryDataResponse) {
+	if err := c.queryChunk(ctx, refIDs, body, opts, response); err != nil {
+		c.bisectQuery(ctx, refIDs, body, opts, response, err)
+	}
+}
+
+// queryChunk 查询成功时写入结果，失败时返回错误
+func (c *CESClient) queryChunk(ctx context.Context, refIDs []string, body *model.BatchListMetricDataRequestBody, opts FrameOptions, response *backend.QueryDataResponse) error {
+	res, err := callWithContext(ctx, func() (*model.BatchListMetricDataResponse, error) {
+		return c.Client.BatchListMetricData(&model.BatchListMetricDataRequest{Body: body})
+	})
+	if err != nil {
+		return err
+	}
+	buildFrames(refIDs, body, opts, res, response)
+	return nil
+}
+
+func (c *CESClient) bisectQuery(ctx context.Context, refIDs []string, body *model.BatchListMetricDataRequestBody, opts FrameOptions,
+	response *backend.QueryDataResponse, err error) {
+	if !isBadRequest(err) || len(refIDs) == 1 {
+		setBatchError(refIDs, err, response)
+		return
+	}
+
+	mid := len(refIDs) / 2
+	left, right := *body, *body
+	left.Metrics = body.Metrics[:mid]
+	right.Metrics = body.Metrics[mid:]
+	leftErr := c.queryChunk(ctx, refIDs[:mid], &left, opts, response)
+	rightErr := c.queryChunk(ctx, refIDs[mid:], &right, opts, response)
+	if leftErr != nil {
+		c.bisectQuery(ctx, refIDs[:mid], &left, opts, response, leftErr)
+	}
+	if rightErr != nil {
+		c.bisectQuery(ctx, refIDs[mid:], &right, opts, response, rightErr)
+	}
+}
+
+func setBatchError(refIDs []string, err error, response *backend.QueryDataResponse) {
+	log.DefaultLogger.Error("BatchListMetricData error", "refIDs", refIDs, "err", err)
+	for _, refID := range refIDs {
+		response.Responses[refID] = errDataResponse(err)
+	}
+}
+
+// metricKey 以namespace、指标名和维度集合标识一个指标，维度与顺序无关
+func metricKey(namespace, metricName string, dims []model.MetricsDimension) string {
+	dimStrs := make([]string, 0, len(dims))
+	for _, dim := range dims {
+		dimStrs = append(dimStrs, fmt.Sprintf("%s:%s", dim.Name, dim.Value))
+	}
+	sort.Strings(dimStrs)
+	return fmt.Sprintf("%s|%s|%s", namespace, metricName, strings.Join(dimStrs, ","))
+}
+
+func buildLabels(namespace string, dims []model.MetricsDimension) data.Labels {
+	labels := data.Labels{
+		"namespace": namespace,
+	}
+	for _, dim := range dims {
+		labels[dim.Name] = dim.Value
+	}
+	return labels
+}
+
+func newMetricFrame(metricName string, labels data.Labels, config *data.FieldConfig, times []time.Time, values []*float64) *data.Frame {
+	valueField := data.NewField(metricName, labels, values)
+	if config != nil {
+		valueField.SetConfig(config)
+	}
+	return data.NewFrame("",
+		data.NewField("Time", nil, times),
+		valueField,
+	)
+}
+
+// buildFrames CES返回的指标顺序和数量与请求不一定一致，按namespace/指标名/维度匹配回refID，
+// 未返回的指标以带提示信息的空frame返回
+func buildFrames(refIDs []string, body *model.BatchListMetricDataRequestBody, opts FrameOptions, res *model.BatchListMetricDataResponse, response *backend.QueryDataResponse) {
+	keyRefIDs := make(map[string][]string, len(refIDs))
+	for i, metric := range body.Metrics {
+		key := metricKey(metric.Namespace, metric.MetricName, metric.Dimensions)
+		keyRefIDs[key] = append(keyRefIDs[key], refIDs[i])
+	}
+
+	if res.Metrics != nil {
+		for _, each := range *(res.Metrics) {
+			var namespace string
+			if each.Namespace != nil {
+				namespace = *each.Namespace
+			}
+			var dims []model.MetricsDimension
+			if each.Dimensions != nil {
+				dims = *each.Dimensions
+			}
+			key := metricKey(namespace, each.MetricName, dims)
+			matched, ok := keyRefIDs[key]
+			if !ok {
+				log.DefaultLogger.Warn("Unexpected metric in BatchListMetricData response", "metric", key)
+				continue
+			}
+			delete(keyRefIDs, key)
+
+			timeDuration := make([]time.Time, 0, len(each.Datapoints))
+			values := make([]*float64, 0, len(each.Datapoints))
+			for _, v := range each.Datapoints {
+				timeDuration = append(timeDuration, time.UnixMilli(v.Timestamp))
+				values = append(values, getValueByFilter(v, body.Filter))
+			}
+			period, _ := strconv.Atoi(body.Period)
+			if opts.AlignPeriod {
+				timeDuration, values = alignPoints(timeDuration, values, time.Duration(period)*time.Second)
+			}
+			if opts.FillGaps {
+				timeDuration, values = fillGaps(timeDuration, values, reportInterval(body.Period))
+			}
+			labels := buildLabels(namespace, dims)
+			if opts.StatLabel {
+				labels["stat"] = body.Filter
+			}
+			config := buildFieldConfig(namespace, each.MetricName, body.Filter, dims, each.Unit)
+			for _, refID := range matched {
+				frame := newMetricFrame(each.MetricName, labels, config, timeDuration, values)
+				response.Responses[refID] = backend.DataResponse{Frames: data.Frames{frame}}
+			}
+		}
+	}
+
+	for i, metric := range body.Metrics {
+		if _, ok := keyRefIDs[metricKey(metric.Namespace, metric.MetricName, metric.Dimensions)]; !ok {
+			continue
+		}
+		labels := buildLabels(metric.Namespace, metric.Dimensions)
+		if opts.StatLabel {
+			labels["stat"] = body.Filter
+		}
+		config := buildFieldConfig(metric.Namespace, metric.MetricName, body.Filter, metric.Dimensions, nil)
+		frame := newMetricFrame(metric.MetricName, labels, config, []time.Time{}, []*float64{})
+		frame.SetMeta(&data.FrameMeta{
+			Notices: []data.Notice{{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("No data returned from CES for metric %s", metric.MetricName),
+			}},
+		})
+		response.Responses[refIDs[i]] = backend.DataResponse{Frames: data.Frames{frame}}
+	}
+}
+
 func getTimestamp() int64 {
 	return time.Now().UnixNano() / 1e6
 }
 
-func (c *CESClient) ListNamespaces() []string {
-	return c.ListMeta(&QueryParam{})
+func (c *CESClient) ListNamespaces(ctx context.Context) []string {
+	return c.ListMeta(ctx, &QueryParam{})
 }
 
-func (c *CESClient) ListDims(namespace string) []string {
-	return c.ListMeta(&QueryParam{Namespace: namespace})
+// ListDims 指定企业项目时只返回企业项目内的资源
+func (c *CESClient) ListDims(ctx context.Context, namespace, enterpriseProjectID string) ([]string, error) {
+	dims := c.ListMeta(ctx, &QueryParam{Namespace: namespace})
+	if enterpriseProjectID == "" {
+		return dims, nil
+	}
+	return c.filterDimsByEnterpriseProject(ctx, enterpriseProjectID, namespace, dims)
 }
 
-func (c *CESClient) ListMetrics(namespace, dimStr string) []string {
-	return c.ListMeta(&QueryParam{Namespace: namespace, DimStr: dimStr})
+// ListMetrics 指定企业项目且资源不属于企业项目时返回空列表
+func (c *CESClient) ListMetrics(ctx context.Context, namespace, dimStr, enterpriseProjectID string) ([]string, error) {
+	if enterpriseProjectID != "" {
+		dims, err := c.filterDimsByEnterpriseProject(ctx, enterpriseProjectID, namespace, []string{dimStr})
+		if err != nil {
+			return nil, err
+		}
+		if len(dims) == 0 {
+			return []string{}, nil
+		}
+	}
+	return c.ListMeta(ctx, &QueryParam{Namespace: namespace, DimStr: dimStr}), nil
 }
 
-func (c *CESClient) Check() error {
+func (c *CESClient) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Check)
+	defer cancel()
 	var limit int32 = 1
-	_, err := c.Client.ListAlarms(&model.ListAlarmsRequest{
-		Limit: &limit,
+	_, err := callWithContext(ctx, func() (*model.ListAlarmsResponse, error) {
+		return c.Client.ListAlarms(&model.ListAlarmsRequest{
+			Limit: &limit,
+		})
 	})
 
 	return err
@@ -145,70 +588,92 @@ type QueryParam struct {
 	DimStr    string
 }
 
-func getMetaUtil(param *QueryParam) MetaUtil {
-	if param.Namespace == "" && param.DimStr == "" {
-		return &NsCache
+// ListMeta 分页查询元数据，请求取消或超时后停止分页，已查询的部分及marker写入缓存，下次请求接着查询
+func (c *CESClient) ListMeta(ctx context.Context, param *QueryParam) []string {
+	metaUtil := c.Caches.getMetaUtil(param)
+	metaCache := metaUtil.getCache()
+	param.Region = c.Region
+	// key以目标账号开头，便于按region/namespace清理
+	key := fmt.Sprintf("%s|%s", c.Domain, metaUtil.buildKey(param))
+	if cachedMeta := metaCache.getCachedMeta(key); cachedMeta != nil && (cachedMeta.Meta != nil || !cachedMeta.isExpired()) {
+		// 过期的缓存直接返回，在后台刷新，不阻塞变量下拉框
+		if cachedMeta.isExpired() {
+			refreshParam := *param
+			metaCache.flights.refresh(key, func(fetchCtx context.Context) ([]string, error) {
+				return c.fetchMeta(fetchCtx, c.Timeouts.max(), metaUtil, &refreshParam, key), nil
+			})
+		}
+		return cachedMeta.Meta
 	}
 
-	if param.DimStr != "" {
-		return &MCache
+	// 相同key的并发请求只执行一次分页查询，其余请求等待其结果，等待时间受各自的context限制
+	meta, err := metaCache.flights.do(ctx, key, func(fetchCtx context.Context) ([]string, error) {
+		return c.fetchMeta(fetchCtx, c.Timeouts.Meta, metaUtil, param, key), nil
+	})
+	if err != nil {
+		log.DefaultLogger.Error("ListMeta cancelled", "query params", *param, "err", err)
+		return nil
 	}
-
-	return &DmCache
+	return meta
 }
 
-func (c *CESClient) ListMeta(param *QueryParam) []string {
-	metaUtil := getMetaUtil(param)
+func (c *CESClient) fetchMeta(ctx context.Context, timeout time.Duration, metaUtil MetaUtil, param *QueryParam, key string) []string {
 	metaCache := metaUtil.getCache()
-	param.Region = c.Region
-	key := metaUtil.buildKey(param)
 	reqParam := metaUtil.buildQuery(param)
-
-	isMetaExist, metaList, meta := getMeta(metaCache, key, reqParam)
-	if metaList != nil || meta != nil {
+	isMetaExist, metaList, meta, ok := getMeta(metaCache, key, reqParam)
+	if ok {
 		return meta
 	}
+	// 刷新已查完的过期缓存时，查完前继续返回旧数据，未查完的部分结果和marker保存在旧条目中，下次接着刷新
+	var stale *CachedMeta
+	if cachedMeta := metaCache.getCachedMeta(key); cachedMeta != nil && cachedMeta.Finished {
+		stale = cachedMeta
+	}
 
 	newCache := metaUtil.newCachedMeta()
+	newCache.Meta = metaList
+	if reqParam.Start != nil {
+		newCache.Marker = *reqParam.Start
+	}
 
-	endFlag := make(chan bool)
-	defer close(endFlag)
-	go func() {
-		for {
-			res, err := c.Client.ListMetrics(reqParam)
-			if err != nil {
-				log.DefaultLogger.Error("ListMetrics error", "detail", err)
-				metaUtil.setDefaultMeta(param, newCache)
-				metaCache.Data.Store(key, newCache)
-				endFlag <- true
-				return
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	progressed := false
+	for {
+		res, err := callWithContext(ctx, func() (*model.ListMetricsResponse, error) {
+			return c.Client.ListMetrics(reqParam)
+		})
+		if err != nil {
+			log.DefaultLogger.Error("ListMetrics error", "query params", *param, "err", err)
+			if stale != nil {
+				pending := *stale
+				pending.Refreshing = metaList
+				pending.RefreshMarker = newCache.Marker
+				pending.retryLater(progressed)
+				metaCache.Data.Store(key, &pending)
+				return stale.Meta
 			}
-			metrics := *(res.Metrics)
-			if len(metrics) == 0 {
-				newCache.Finished = true
-				metaCache.Data.Store(key, newCache)
-				endFlag <- true
-				return
+			if len(metaList) == 0 {
+				metaUtil.setDefaultMeta(param, newCache)
 			}
-
-			procMetaList(metrics, metaUtil, isMetaExist, &metaList)
-			reqParam.Start = &(res.MetaData.Marker)
-			newCache.Marker = res.MetaData.Marker
-			newCache.Meta = metaList
-			newCache.UpdateTime = getTimestamp()
+			// 未查完的缓存视为过期，下次请求从marker继续
+			newCache.retryLater(progressed)
+			metaCache.Data.Store(key, newCache)
+			return newCache.Meta
 		}
-	}()
-	select {
-	case <-time.After(5 * time.Second):
-		log.DefaultLogger.Error("ListMeta timeout", "query params", *param)
-		if len(metaList) == 0 {
-			metaUtil.setDefaultMeta(param, newCache)
+		metrics := *(res.Metrics)
+		if len(metrics) == 0 {
+			newCache.Finished = true
+			newCache.UpdateTime = getTimestamp()
 			metaCache.Data.Store(key, newCache)
-			return metaCache.getCachedMeta(key).Meta
+			return metaList
 		}
-		return metaList
-	case <-endFlag:
-		return metaList
+
+		procMetaList(metrics, metaUtil, isMetaExist, &metaList)
+		reqParam.Start = &(res.MetaData.Marker)
+		newCache.Marker = res.MetaData.Marker
+		newCache.Meta = metaList
+		progressed = true
 	}
 }
 
@@ -226,23 +691,28 @@ func procMetaList(metrics []model.MetricInfoList, metaUtil MetaUtil, isMetaExist
 	}
 }
 
-func getMeta(metaCache *MetaCache, key string, reqParam *model.ListMetricsRequest) (map[string]bool, []string, []string) {
+// getMeta 缓存未过期时返回缓存的数据，否则返回需要继续查询的部分结果
+func getMeta(metaCache *MetaCache, key string, reqParam *model.ListMetricsRequest) (map[string]bool, []string, []string, bool) {
 	isMetaExist := make(map[string]bool)
 	var metaList []string
 	cachedMeta := metaCache.getCachedMeta(key)
 	if cachedMeta != nil {
 		if !cachedMeta.isExpired() {
-			return nil, nil, cachedMeta.Meta
+			return nil, nil, cachedMeta.Meta, true
 		}
 
-		// 大租户可能被流控，接着上次的marker继续请求
-		if !cachedMeta.Finished {
-			reqParam.Start = &cachedMeta.Marker
-			metaList = cachedMeta.Meta
+		// 大租户可能被流控，接着上次的marker继续请求；缓存条目可能被并发读取，复制后再追加
+		marker, partial := cachedMeta.Marker, cachedMeta.Meta
+		if cachedMeta.Finished {
+			marker, partial = cachedMeta.RefreshMarker, cachedMeta.Refreshing
+		}
+		if !cachedMeta.Finished || marker != "" {
+			reqParam.Start = &marker
+			metaList = append([]string(nil), partial...)
 			for i := range metaList {
 				isMetaExist[metaList[i]] = true
 			}
 		}
 	}
-	return isMetaExist, metaList, nil
+	return isMetaExist, metaList, nil, false
 }
@@ -13,10 +13,14 @@ import (
 )
 
 type MetaConf struct {
-	Regions    []string                       `yaml:"regions"`
-	Namespaces map[string][]string            `yaml:"namespaces"` // key: region, value: namespaceList
-	Dimensions map[string]map[string][]string `yaml:"dimensions"` // key: region|namespace, value: map[dimKey]dimValues
-	Metrics    map[string][]string            `yaml:"metrics"`    // key: namespace|dimKey, value: metrics
+	Regions       []string                       `yaml:"regions"`
+	Namespaces    map[string][]string            `yaml:"namespaces"`    // key: region, value: namespaceList
+	Dimensions    map[string]map[string][]string `yaml:"dimensions"`    // key: region|namespace, value: map[dimKey]dimValues
+	Metrics       map[string][]string            `yaml:"metrics"`       // key: namespace|dimKey, value: metrics
+	MetricCatalog map[string]MetricCatalogItem   `yaml:"metricCatalog"` // key: namespace|metricName, value: 单位及展示信息
+	Endpoints     map[string]string              `yaml:"endpoints"`     // key: region, value: CES endpoint
+	// key: namespace, value: 企业项目资源类型
+	EnterpriseProjectResourceTypes map[string][]string `yaml:"enterpriseProjectResourceTypes"`
 }
 
 var GetMeta = initMetaConf()
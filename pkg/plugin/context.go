@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultQueryTimeout = 30 * time.Second
+	defaultMetaTimeout  = 5 * time.Second
+	defaultCheckTimeout = 10 * time.Second
+)
+
+// Timeouts 各类CES操作的超时时间，可在数据源配置中按秒设置
+type Timeouts struct {
+	Query time.Duration // 查询监控数据
+	Meta  time.Duration // 查询服务/资源/指标列表
+	Check time.Duration // 健康检查
+}
+
+func newTimeouts(querySeconds, metaSeconds, checkSeconds int) Timeouts {
+	timeouts := Timeouts{Query: defaultQueryTimeout, Meta: defaultMetaTimeout, Check: defaultCheckTimeout}
+	if querySeconds > 0 {
+		timeouts.Query = time.Duration(querySeconds) * time.Second
+	}
+	if metaSeconds > 0 {
+		timeouts.Meta = time.Duration(metaSeconds) * time.Second
+	}
+	if checkSeconds > 0 {
+		timeouts.Check = time.Duration(checkSeconds) * time.Second
+	}
+	return timeouts
+}
+
+func (t Timeouts) max() time.Duration {
+	res := t.Query
+	if t.Meta > res {
+		res = t.Meta
+	}
+	if t.Check > res {
+		res = t.Check
+	}
+	if res <= 0 {
+		res = defaultQueryTimeout
+	}
+	return res
+}
+
+// callWithContext SDK接口不支持context，请求取消或超时后直接返回，未完成的SDK调用在HTTP超时后结束
+func callWithContext[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		res T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() { done <- r }()
+		defer recoverPanic(&r.err)
+		r.res, r.err = call()
+	}()
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case r := <-done:
+		return r.res, r.err
+	}
+}
+
+// recoverPanic 后台goroutine中的panic会导致插件进程退出，转换为错误返回，需直接defer调用
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic: %v", r)
+	}
+}
@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/global"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/config"
+	iam "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/iam/v3"
+	iamModel "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/iam/v3/model"
+)
+
+const (
+	authTypeAKSK      = "aksk"
+	authTypeTemporary = "temporary"
+	authTypeMetadata  = "metadata"
+
+	// ECS元数据服务的临时凭证接口，凭证来自ECS绑定的委托
+	defaultMetadataEndpoint = "http://169.254.169.254/openstack/latest/securitykey"
+
+	// 临时凭证过期前提前刷新的时间窗口
+	credentialRefreshWindow = 5 * time.Minute
+	// 凭证未指定过期时间时的重新获取间隔
+	credentialReloadInterval = 5 * time.Minute
+	credentialRequestTimeout = 5 * time.Second
+	maxLoggedBodyLength      = 512
+
+	defaultIAMEndpoint    = "https://iam.myhuaweicloud.com"
+	defaultAgencyDuration = 3600
+)
+
+// Credential 访问CES使用的凭证，临时凭证需携带SecurityToken
+type Credential struct {
+	AK            string
+	SK            string
+	SecurityToken string
+	ExpiresAt     time.Time // 零值表示不过期
+}
+
+func (c *Credential) needRefresh(fetchedAt time.Time) bool {
+	if c.ExpiresAt.IsZero() {
+		return time.Since(fetchedAt) > credentialReloadInterval
+	}
+	return time.Until(c.ExpiresAt) < credentialRefreshWindow
+}
+
+// temporaryCredentialResponse 凭证文件及凭证endpoint的返回格式，与ECS元数据securitykey接口一致
+type temporaryCredentialResponse struct {
+	Credential *struct {
+		Access        string `json:"access"`
+		Secret        string `json:"secret"`
+		SecurityToken string `json:"securitytoken"`
+		ExpiresAt     string `json:"expires_at"`
+	} `json:"credential"`
+}
+
+func parseTemporaryCredential(body []byte) (*Credential, error) {
+	var resp temporaryCredentialResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse temporary credential error: %s", err.Error())
+	}
+	if resp.Credential == nil || resp.Credential.Access == "" || resp.Credential.Secret == "" {
+		return nil, errors.New("temporary credential is empty")
+	}
+
+	return newTemporaryCredential(resp.Credential.Access, resp.Credential.Secret,
+		resp.Credential.SecurityToken, resp.Credential.ExpiresAt)
+}
+
+func newTemporaryCredential(ak, sk, securityToken, expiresAt string) (*Credential, error) {
+	cred := &Credential{
+		AK:            ak,
+		SK:            sk,
+		SecurityToken: securityToken,
+	}
+	if expiresAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("parse credential expires_at error: %s", err.Error())
+		}
+		cred.ExpiresAt = t
+	}
+	return cred, nil
+}
+
+// CredentialProvider 提供当前有效的凭证，凭证未刷新时返回同一个对象
+type CredentialProvider interface {
+	Retrieve() (*Credential, error)
+}
+
+type staticCredentialProvider struct {
+	cred *Credential
+}
+
+func (p *staticCredentialProvider) Retrieve() (*Credential, error) {
+	return p.cred, nil
+}
+
+// refreshingCredentialProvider 缓存凭证，过期前通过fetch重新获取
+type refreshingCredentialProvider struct {
+	fetch     func() (*Credential, error)
+	mu        sync.Mutex
+	cred      *Credential
+	fetchedAt time.Time
+}
+
+func (p *refreshingCredentialProvider) Retrieve() (*Credential, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cred != nil && !p.cred.needRefresh(p.fetchedAt) {
+		return p.cred, nil
+	}
+
+	cred, err := p.fetch()
+	if err != nil {
+		// 刷新失败时，未过期的旧凭证仍可继续使用
+		if p.cred != nil && (p.cred.ExpiresAt.IsZero() || time.Now().Before(p.cred.ExpiresAt)) {
+			return p.cred, nil
+		}
+		return nil, err
+	}
+	p.cred = cred
+	p.fetchedAt = time.Now()
+	return cred, nil
+}
+
+func newFileCredentialProvider(path string) CredentialProvider {
+	return &refreshingCredentialProvider{
+		fetch: func() (*Credential, error) {
+			body, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return parseTemporaryCredential(body)
+		},
+	}
+}
+
+// newEndpointCredentialProvider 凭证endpoint一般为本机或内网服务，不经过代理
+func newEndpointCredentialProvider(endpoint string) CredentialProvider {
+	client := &http.Client{Transport: &http.Transport{}, Timeout: credentialRequestTimeout}
+	return &refreshingCredentialProvider{
+		fetch: func() (*Credential, error) {
+			resp, err := client.Get(endpoint)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			// 返回体只记录在服务端日志中，避免通过错误信息读取任意内网地址的内容
+			if resp.StatusCode >= http.StatusBadRequest {
+				if len(body) > maxLoggedBodyLength {
+					body = body[:maxLoggedBodyLength]
+				}
+				log.DefaultLogger.Error("Get temporary credential failed", "status", resp.StatusCode, "body", string(body))
+				return nil, fmt.Errorf("get temporary credential failed, status: %d", resp.StatusCode)
+			}
+			return parseTemporaryCredential(body)
+		},
+	}
+}
+
+// newCredentialProvider 临时凭证模式下优先从凭证文件、其次从凭证endpoint获取并自动刷新，
+// 均未配置时使用配置的AK/SK/SecurityToken；元数据模式下从ECS元数据服务获取并自动刷新
+func newCredentialProvider(c *CloudEyeSettings) (CredentialProvider, error) {
+	switch c.AuthType {
+	case "", authTypeAKSK:
+		return &staticCredentialProvider{cred: &Credential{AK: c.AK, SK: c.SK}}, nil
+	case authTypeTemporary:
+		if c.CredentialsFile != "" {
+			return newFileCredentialProvider(c.CredentialsFile), nil
+		}
+		if c.CredentialsEndpoint != "" {
+			return newEndpointCredentialProvider(c.CredentialsEndpoint), nil
+		}
+		if c.SecurityToken == "" {
+			return nil, errors.New("security token is required for temporary credentials")
+		}
+		return &staticCredentialProvider{cred: &Credential{AK: c.AK, SK: c.SK, SecurityToken: c.SecurityToken}}, nil
+	case authTypeMetadata:
+		// 配置的凭证endpoint可替代默认的元数据地址，便于测试
+		if c.CredentialsEndpoint != "" {
+			return newEndpointCredentialProvider(c.CredentialsEndpoint), nil
+		}
+		return newEndpointCredentialProvider(defaultMetadataEndpoint), nil
+	}
+	return nil, fmt.Errorf("unsupported auth type: %s", c.AuthType)
+}
+
+// newAgencyCredentialProvider 使用base凭证通过IAM委托获取目标账号的临时凭证，用于跨账号监控
+func newAgencyCredentialProvider(base CredentialProvider, c *CloudEyeSettings, domainName string, transport *http.Transport) CredentialProvider {
+	iamEndpoint := c.IAMEndpoint
+	if iamEndpoint == "" {
+		iamEndpoint = defaultIAMEndpoint
+	}
+	duration := int32(c.AgencyDuration)
+	if duration <= 0 {
+		duration = defaultAgencyDuration
+	}
+	return &refreshingCredentialProvider{
+		fetch: func() (*Credential, error) {
+			baseCred, err := base.Retrieve()
+			if err != nil {
+				return nil, err
+			}
+
+			creBuilder := global.NewCredentialsBuilder().
+				WithAk(baseCred.AK).
+				WithSk(baseCred.SK)
+			if baseCred.SecurityToken != "" {
+				creBuilder = creBuilder.WithSecurityToken(baseCred.SecurityToken)
+			}
+			cred, err := creBuilder.SafeBuild()
+			if err != nil {
+				return nil, fmt.Errorf("create IAM credentials failed: %s", err.Error())
+			}
+			hcClient, err := iam.IamClientBuilder().
+				WithCredential(cred).
+				WithHttpConfig(config.DefaultHttpConfig().WithHttpTransport(transport)).
+				WithEndpoint(iamEndpoint).
+				SafeBuild()
+			if err != nil {
+				return nil, fmt.Errorf("create IAM client failed: %s", err.Error())
+			}
+			client := iam.NewIamClient(hcClient)
+
+			res, err := client.CreateTemporaryAccessKeyByAgency(&iamModel.CreateTemporaryAccessKeyByAgencyRequest{
+				Body: &iamModel.CreateTemporaryAccessKeyByAgencyRequestBody{
+					Auth: &iamModel.AgencyAuth{
+						Identity: &iamModel.AgencyAuthIdentity{
+							Methods: []iamModel.AgencyAuthIdentityMethods{iamModel.GetAgencyAuthIdentityMethodsEnum().ASSUME_ROLE},
+							AssumeRole: &iamModel.IdentityAssumerole{
+								AgencyName:      c.AgencyName,
+								DomainName:      &domainName,
+								DurationSeconds: &duration,
+							},
+						},
+					},
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("assume agency %s of domain %s failed: %s", c.AgencyName, domainName, err.Error())
+			}
+			if res.Credential == nil {
+				return nil, errors.New("agency credential is empty")
+			}
+			return newTemporaryCredential(res.Credential.Access, res.Credential.Secret,
+				res.Credential.Securitytoken, res.Credential.ExpiresAt)
+		},
+	}
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// metadataServer 模拟ECS元数据securitykey接口，status非200时返回错误信息
+type metadataServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	hits      int
+	status    int
+	expiresAt time.Time
+}
+
+func newMetadataServer(expiresAt time.Time) *metadataServer {
+	s := &metadataServer{status: http.StatusOK, expiresAt: expiresAt}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hits++
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			_, _ = w.Write([]byte("internal secret"))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"credential":{"access":"AK%d","secret":"SK%d","securitytoken":"TOKEN%d","expires_at":"%s"}}`,
+			s.hits, s.hits, s.hits, s.expiresAt.UTC().Format(time.RFC3339Nano))
+	}))
+	return s
+}
+
+func (s *metadataServer) set(status int, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.expiresAt = expiresAt
+}
+
+func (s *metadataServer) hitCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+func newMetadataProvider(t *testing.T, endpoint string) CredentialProvider {
+	t.Helper()
+	provider, err := newCredentialProvider(&CloudEyeSettings{AuthType: authTypeMetadata, CredentialsEndpoint: endpoint})
+	assert.NoError(t, err)
+	return provider
+}
+
+func TestMetadataCredentialParse(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	server := newMetadataServer(expiresAt)
+	defer server.Close()
+	provider := newMetadataProvider(t, server.URL)
+
+	cred, err := provider.Retrieve()
+	assert.NoError(t, err)
+	assert.Equal(t, "AK1", cred.AK)
+	assert.Equal(t, "SK1", cred.SK)
+	assert.Equal(t, "TOKEN1", cred.SecurityToken)
+	assert.True(t, expiresAt.Equal(cred.ExpiresAt))
+
+	// 未进入刷新窗口时使用缓存的凭证
+	cached, err := provider.Retrieve()
+	assert.NoError(t, err)
+	assert.Same(t, cred, cached)
+	assert.Equal(t, 1, server.hitCount())
+}
+
+func TestMetadataCredentialRefreshWindow(t *testing.T) {
+	server := newMetadataServer(time.Now().Add(credentialRefreshWindow / 2))
+	defer server.Close()
+	provider := newMetadataProvider(t, server.URL)
+
+	cred, err := provider.Retrieve()
+	assert.NoError(t, err)
+	assert.Equal(t, "AK1", cred.AK)
+
+	// 即将过期的凭证在刷新窗口内重新获取
+	server.set(http.StatusOK, time.Now().Add(time.Hour))
+	cred, err = provider.Retrieve()
+	assert.NoError(t, err)
+	assert.Equal(t, "AK2", cred.AK)
+	assert.Equal(t, 2, server.hitCount())
+}
+
+func TestMetadataCredentialKeepOldOnFailedRefresh(t *testing.T) {
+	server := newMetadataServer(time.Now().Add(credentialRefreshWindow / 2))
+	defer server.Close()
+	provider := newMetadataProvider(t, server.URL)
+
+	cred, err := provider.Retrieve()
+	assert.NoError(t, err)
+
+	// 刷新失败时未过期的旧凭证仍可使用
+	server.set(http.StatusInternalServerError, time.Time{})
+	old, err := provider.Retrieve()
+	assert.NoError(t, err)
+	assert.Same(t, cred, old)
+	assert.Equal(t, 2, server.hitCount())
+}
+
+func TestMetadataCredentialFailedWithoutBody(t *testing.T) {
+	server := newMetadataServer(time.Time{})
+	defer server.Close()
+	server.set(http.StatusInternalServerError, time.Time{})
+	provider := newMetadataProvider(t, server.URL)
+
+	_, err := provider.Retrieve()
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "internal secret")
+}
@@ -3,10 +3,13 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -15,28 +18,81 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
+	eps "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/eps/v1"
 )
 
 type commonConf struct {
-	ProjectID       string `json:"projectId"`
-	CESEndpoint     string `json:"cesEndpoint"`
-	Region          string `json:"region"`
-	MetaConfEnabled bool   `json:"metaConfEnabled"`
+	ProjectID           string           `json:"projectId"`
+	CESEndpoint         string           `json:"cesEndpoint"`
+	RegionEndpoints     []RegionEndpoint `json:"regionEndpoints"`
+	CESEndpoints        []RegionEndpoint `json:"cesEndpoints"`
+	Region              string           `json:"region"`
+	MetaConfEnabled     bool             `json:"metaConfEnabled"`
+	TLSSkipVerify       bool             `json:"tlsSkipVerify"`
+	ProxyHost           string           `json:"proxyHost"`
+	ProxyPort           int              `json:"proxyPort"`
+	AuthType            string           `json:"authType"`
+	CredentialsFile     string           `json:"credentialsFile"`
+	CredentialsEndpoint string           `json:"credentialsEndpoint"`
+	IAMEndpoint         string           `json:"iamEndpoint"`
+	AgencyDomain        string           `json:"agencyDomain"`
+	AgencyName          string           `json:"agencyName"`
+	AgencyDuration      int              `json:"agencyDuration"`
+	EPSEndpoint         string           `json:"epsEndpoint"`
+	QueryTimeout        int              `json:"queryTimeout"`
+	MetaTimeout         int              `json:"metaTimeout"`
+	CheckTimeout        int              `json:"checkTimeout"`
+	NamespaceCacheTTL   int              `json:"namespaceCacheTTL"`
+	DimensionCacheTTL   int              `json:"dimensionCacheTTL"`
+	MetricCacheTTL      int              `json:"metricCacheTTL"`
 }
 
 type CloudEyeSettings struct {
-	ProjectID       string `json:"projectId"`
-	CESEndpoint     string `json:"cesEndpoint"`
-	Region          string `json:"region"`
-	MetaConfEnabled bool   `json:"metaConfEnabled"`
-	AK              string `json:"accessKey"`
-	SK              string `json:"secretKey"`
+	ProjectID   string `json:"projectId"`
+	CESEndpoint string `json:"cesEndpoint"`
+	// 单region模式下额外配置的region/project，Region/ProjectID/CESEndpoint为默认项
+	RegionEndpoints []RegionEndpoint `json:"regionEndpoints"`
+	// 多region模式下按region覆盖CES endpoint，用于SDK未收录的新region或VPC endpoint
+	CESEndpoints    map[string]string `json:"cesEndpoints"`
+	Region          string            `json:"region"`
+	MetaConfEnabled bool              `json:"metaConfEnabled"`
+	AK              string            `json:"accessKey"`
+	SK              string            `json:"secretKey"`
+	TLSSkipVerify   bool              `json:"tlsSkipVerify"`
+	TLSCACert       string            `json:"tlsCACert"`
+	TLSClientCert   string            `json:"tlsClientCert"`
+	TLSClientKey    string            `json:"tlsClientKey"`
+	ProxyHost       string            `json:"proxyHost"`
+	ProxyPort       int               `json:"proxyPort"`
+	ProxyUsername   string            `json:"proxyUsername"`
+	ProxyPassword   string            `json:"proxyPassword"`
+	// 认证方式：aksk(默认，永久AK/SK)、temporary(临时AK/SK+SecurityToken)
+	AuthType            string `json:"authType"`
+	SecurityToken       string `json:"securityToken"`
+	CredentialsFile     string `json:"credentialsFile"`
+	CredentialsEndpoint string `json:"credentialsEndpoint"`
+	// 跨账号监控：通过IAM委托获取目标账号(AgencyDomain)的临时凭证，查询可指定其他目标账号
+	IAMEndpoint    string `json:"iamEndpoint"`
+	AgencyDomain   string `json:"agencyDomain"`
+	AgencyName     string `json:"agencyName"`
+	AgencyDuration int    `json:"agencyDuration"`
+	// 按企业项目过滤资源时使用的EPS endpoint
+	EPSEndpoint string    `json:"epsEndpoint"`
+	Timeouts    Timeouts  `json:"-"`
+	CacheTTLs   CacheTTLs `json:"-"`
 }
 
 type CustomBatchListMetricDataRequestBody struct {
 	model.BatchListMetricDataRequestBody
-	RefIDs []string `json:"refIDs"`
-	Region string   `json:"region"`
+	RefIDs        []string `json:"refIDs"`
+	Region        string   `json:"region"`
+	FillGaps      bool     `json:"fillGaps"`
+	AlignPeriod   bool     `json:"alignPeriod"`
+	MaxDataPoints int64    `json:"maxDataPoints"`
+	IntervalMs    int64    `json:"intervalMs"`
+	AgencyDomain  string   `json:"agencyDomain"`
+	// 只查询企业项目内的资源
+	EnterpriseProjectID string `json:"enterpriseProjectId"`
 }
 
 func recoverWrapper(handler func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
@@ -46,6 +102,7 @@ func recoverWrapper(handler func(w http.ResponseWriter, r *http.Request)) http.H
 			log.DefaultLogger.Info(fmt.Sprintf("Path:%s, cost %d ms", r.URL.Path, time.Since(start).Milliseconds()))
 			if err := recover(); err != nil {
 				log.DefaultLogger.Error("Panic recovered", "err", err)
+				writeResult(w, "", nil, fmt.Errorf("internal error: %v", err))
 			}
 		}()
 		handler(w, r)
@@ -66,6 +123,7 @@ func NewCloudEye() datasource.ServeOpts {
 	mux.HandleFunc("/dimensions", recoverWrapper(data.listDims))
 	mux.HandleFunc("/metrics", recoverWrapper(data.listMetrics))
 	mux.HandleFunc("/metric-data", recoverWrapper(data.listMetricData))
+	mux.HandleFunc("/cache", recoverWrapper(data.purgeCache))
 
 	httpResourceHandler := httpadapter.New(mux)
 	return datasource.ServeOpts{
@@ -79,15 +137,50 @@ type CloudEyeDatasource struct {
 	im instancemgmt.InstanceManager
 }
 
+// QueryData handles multiple queries and returns multiple responses.
+// Queries are grouped by region/filter/period/time range and sent to CES as batch requests.
 func (ds *CloudEyeDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
-	// create response struct
 	response := backend.NewQueryDataResponse()
+	inst, err := ds.getInstance(ctx, req.PluginContext)
+	if err != nil {
+		log.DefaultLogger.Error("Get datasource instance failed", "err", err.Error())
+		return nil, err
+	}
+
+	for _, batch := range buildBatchQueries(req.Queries, inst.settings.defaultRegion(), response) {
+		cesClient, err := inst.getAccountClient(batch.Region, batch.AgencyDomain)
+		if err != nil {
+			log.DefaultLogger.Error("Create CES client failed", "region", batch.Region, "err", err.Error())
+			for _, refID := range batch.RefIDs {
+				mergeDataResponse(response, refID, backend.DataResponse{Error: err})
+			}
+			continue
+		}
+		refIDs, batchReq := batch.RefIDs, batch.Req
+		if batch.EnterpriseProjectID != "" {
+			refIDs, batchReq = cesClient.filterBatchByEnterpriseProject(ctx, batch.EnterpriseProjectID, refIDs, batchReq, response)
+			if len(refIDs) == 0 {
+				continue
+			}
+		}
+		res, err := cesClient.BatchQuery(ctx, refIDs, batchReq, batch.Options)
+		if err != nil {
+			log.DefaultLogger.Error("BatchQuery failed", "region", batch.Region, "err", err.Error())
+			for _, refID := range batch.RefIDs {
+				mergeDataResponse(response, refID, backend.DataResponse{Error: err})
+			}
+			continue
+		}
+		for refID, eachRes := range res.Responses {
+			mergeDataResponse(response, refID, eachRes)
+		}
+	}
 	return response, nil
 }
 
 func (ds *CloudEyeDatasource) listMetricData(rw http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
-	cfg, err := LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	inst, err := ds.getInstance(ctx, httpadapter.PluginConfigFromContext(ctx))
 	if err != nil {
 		writeResult(rw, "", nil, err)
 		return
@@ -99,26 +192,69 @@ func (ds *CloudEyeDatasource) listMetricData(rw http.ResponseWriter, req *http.R
 		return
 	}
 
-	refIDs, batchReq := buildCustomBatchQueryParams(bodyBytes, cfg)
-	cesClient := &CESClient{Client: GetCESClient(cfg)}
-	res, err := cesClient.BatchQuery(refIDs, batchReq)
+	cfg := *inst.settings
+	batch, err := buildCustomBatchQueryParams(bodyBytes, &cfg)
 	if err != nil {
 		writeResult(rw, "", nil, err)
 		return
 	}
-	writeResult(rw, "data", res, nil)
+	cesClient, err := inst.getAccountClient(cfg.Region, cfg.AgencyDomain)
+	if err != nil {
+		writeResult(rw, "", nil, err)
+		return
+	}
+	response := backend.NewQueryDataResponse()
+	if batch.EnterpriseProjectID != "" {
+		batch.RefIDs, batch.Req = cesClient.filterBatchByEnterpriseProject(ctx, batch.EnterpriseProjectID, batch.RefIDs, batch.Req, response)
+		if len(batch.RefIDs) == 0 {
+			writeResult(rw, "data", response, nil)
+			return
+		}
+	}
+	res, err := cesClient.BatchQuery(ctx, batch.RefIDs, batch.Req, batch.Options)
+	if err != nil {
+		writeResult(rw, "", nil, err)
+		return
+	}
+	for refID, eachRes := range res.Responses {
+		response.Responses[refID] = eachRes
+	}
+	writeResult(rw, "data", response, nil)
 }
 
-func buildCustomBatchQueryParams(reqBodyBytes []byte, setting *CloudEyeSettings) ([]string, *model.BatchListMetricDataRequest) {
+func buildCustomBatchQueryParams(reqBodyBytes []byte, setting *CloudEyeSettings) (*batchQuery, error) {
 	var reqBody CustomBatchListMetricDataRequestBody
 	err := json.Unmarshal(reqBodyBytes, &reqBody)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	setting.Region = reqBody.Region
-	return reqBody.RefIDs, &model.BatchListMetricDataRequest{
-		Body: &reqBody.BatchListMetricDataRequestBody,
+	if reqBody.AgencyDomain != "" {
+		setting.AgencyDomain = reqBody.AgencyDomain
+	}
+	if reqBody.Period == autoPeriod {
+		reqBody.Period = selectPeriod(time.Duration(reqBody.IntervalMs)*time.Millisecond, reqBody.MaxDataPoints, reqBody.From, reqBody.To)
+	}
+	// 整批共用的参数错误会导致每个指标都查询失败，发送前校验
+	if !validFilters[reqBody.Filter] {
+		return nil, fmt.Errorf("unsupported filter: %s", reqBody.Filter)
 	}
+	if !isValidPeriod(reqBody.Period) {
+		return nil, fmt.Errorf("unsupported period: %s", reqBody.Period)
+	}
+	if reqBody.From >= reqBody.To {
+		return nil, errors.New("from must be earlier than to")
+	}
+	return &batchQuery{
+		Region:              reqBody.Region,
+		AgencyDomain:        reqBody.AgencyDomain,
+		EnterpriseProjectID: reqBody.EnterpriseProjectID,
+		RefIDs:              reqBody.RefIDs,
+		Req: &model.BatchListMetricDataRequest{
+			Body: &reqBody.BatchListMetricDataRequestBody,
+		},
+		Options: FrameOptions{FillGaps: reqBody.FillGaps, AlignPeriod: reqBody.AlignPeriod},
+	}, nil
 }
 
 func buildHealthCheckRes(err error) *backend.CheckHealthResult {
@@ -139,15 +275,19 @@ func buildHealthCheckRes(err error) *backend.CheckHealthResult {
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
 func (ds *CloudEyeDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	conf, err := LoadSettings(req.PluginContext)
+	inst, err := ds.getInstance(ctx, req.PluginContext)
 	if err != nil {
-		log.DefaultLogger.Error("LoadSettings failed", "err", err.Error())
+		log.DefaultLogger.Error("Get datasource instance failed", "err", err.Error())
 		return buildHealthCheckRes(err), err
 	}
 
-	cesClient := &CESClient{Client: GetCESClient(conf)}
-	err = cesClient.Check()
-	resetCache()
+	cesClient, err := inst.getClient(inst.settings.defaultRegion())
+	if err != nil {
+		return buildHealthCheckRes(err), err
+	}
+	err = cesClient.Check(ctx)
+	// 只清理当前数据源的缓存，不影响其他数据源
+	inst.caches.reset()
 	return buildHealthCheckRes(err), err
 }
 
@@ -175,23 +315,47 @@ func writeResult(rw http.ResponseWriter, path string, val interface{}, err error
 
 func (ds *CloudEyeDatasource) listRegions(rw http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
-	cfg, err := LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	inst, err := ds.getInstance(ctx, httpadapter.PluginConfigFromContext(ctx))
 	if err != nil {
 		writeResult(rw, "", nil, err)
 		return
 	}
-	if cfg.Region != "" && cfg.CESEndpoint != "" {
-		res := []string{cfg.Region}
+	if entries := inst.settings.specificRegions(); len(entries) > 0 {
+		res := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			res = append(res, entry.Region)
+		}
 		writeResult(rw, "regions", res, nil)
 		return
 	}
-	writeResult(rw, "regions", GetMeta().Regions, nil)
+	writeResult(rw, "regions", mergeRegions(GetMeta().Regions, inst.settings.CESEndpoints, GetMeta().Endpoints), nil)
+}
+
+// mergeRegions 配置了endpoint的region即使不在metric.yaml的region列表中也可选择
+func mergeRegions(regions []string, endpoints ...map[string]string) []string {
+	res := append([]string{}, regions...)
+	isExist := make(map[string]bool, len(regions))
+	for _, r := range regions {
+		isExist[r] = true
+	}
+	for _, m := range endpoints {
+		var extra []string
+		for r := range m {
+			if !isExist[r] {
+				isExist[r] = true
+				extra = append(extra, r)
+			}
+		}
+		sort.Strings(extra)
+		res = append(res, extra...)
+	}
+	return res
 }
 
 func (ds *CloudEyeDatasource) listNamespaces(rw http.ResponseWriter, req *http.Request) {
 	log.DefaultLogger.Info("List namespaces", "URL", req.URL.String())
 	ctx := req.Context()
-	cfg, err := LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	inst, err := ds.getInstance(ctx, httpadapter.PluginConfigFromContext(ctx))
 	if err != nil {
 		writeResult(rw, "", nil, err)
 		return
@@ -202,22 +366,25 @@ func (ds *CloudEyeDatasource) listNamespaces(rw http.ResponseWriter, req *http.R
 		return
 	}
 	reqRegion := params.Get("region")
-	cfg.Region = reqRegion
 
-	if cfg.MetaConfEnabled {
+	if inst.settings.MetaConfEnabled {
 		writeResult(rw, "namespaces", GetMeta().Namespaces[reqRegion], nil)
 		return
 	}
 
-	cesClient := &CESClient{Client: GetCESClient(cfg), Region: reqRegion}
-	res := cesClient.ListNamespaces()
+	cesClient, err := inst.getAccountClient(reqRegion, params.Get("agencyDomain"))
+	if err != nil {
+		writeResult(rw, "", nil, err)
+		return
+	}
+	res := cesClient.ListNamespaces(ctx)
 	writeResult(rw, "namespaces", res, nil)
 }
 
 func (ds *CloudEyeDatasource) listDims(rw http.ResponseWriter, req *http.Request) {
 	log.DefaultLogger.Info("List dimensions", "URL", req.URL.String())
 	ctx := req.Context()
-	cfg, err := LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	inst, err := ds.getInstance(ctx, httpadapter.PluginConfigFromContext(ctx))
 	if err != nil {
 		writeResult(rw, "", nil, err)
 		return
@@ -230,22 +397,31 @@ func (ds *CloudEyeDatasource) listDims(rw http.ResponseWriter, req *http.Request
 	}
 	reqRegion := params.Get("region")
 	reqNamespace := params.Get("namespace")
-	cfg.Region = reqRegion
+	reqEnterpriseProject := params.Get("enterpriseProjectId")
 
-	if cfg.MetaConfEnabled {
+	if inst.settings.MetaConfEnabled && reqEnterpriseProject == "" {
 		writeResult(rw, "dimensions", LoadDimensions(reqRegion, reqNamespace), nil)
 		return
 	}
 
-	cesClient := &CESClient{Client: GetCESClient(cfg), Region: reqRegion}
-	res := cesClient.ListDims(reqNamespace)
-	writeResult(rw, "dimensions", res, nil)
+	cesClient, err := inst.getAccountClient(reqRegion, params.Get("agencyDomain"))
+	if err != nil {
+		writeResult(rw, "", nil, err)
+		return
+	}
+	var res []string
+	if inst.settings.MetaConfEnabled {
+		res, err = cesClient.filterDimsByEnterpriseProject(ctx, reqEnterpriseProject, reqNamespace, LoadDimensions(reqRegion, reqNamespace))
+	} else {
+		res, err = cesClient.ListDims(ctx, reqNamespace, reqEnterpriseProject)
+	}
+	writeResult(rw, "dimensions", res, err)
 }
 
 func (ds *CloudEyeDatasource) listMetrics(rw http.ResponseWriter, req *http.Request) {
 	log.DefaultLogger.Info("List metrics", "URL", req.URL.String())
 	ctx := req.Context()
-	cfg, err := LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	inst, err := ds.getInstance(ctx, httpadapter.PluginConfigFromContext(ctx))
 	if err != nil {
 		writeResult(rw, "", nil, err)
 		return
@@ -257,32 +433,249 @@ func (ds *CloudEyeDatasource) listMetrics(rw http.ResponseWriter, req *http.Requ
 		return
 	}
 	reqRegion := params.Get("region")
-	cfg.Region = reqRegion
+	reqNamespace := params.Get("namespace")
+	reqDimStr := params.Get("dimstr")
+	reqEnterpriseProject := params.Get("enterpriseProjectId")
+
+	if inst.settings.MetaConfEnabled && reqEnterpriseProject == "" {
+		writeResult(rw, "metrics", LoadMetrics(reqNamespace, reqDimStr), nil)
+		return
+	}
+	cesClient, err := inst.getAccountClient(reqRegion, params.Get("agencyDomain"))
+	if err != nil {
+		writeResult(rw, "", nil, err)
+		return
+	}
+	if !inst.settings.MetaConfEnabled {
+		res, err := cesClient.ListMetrics(ctx, reqNamespace, reqDimStr, reqEnterpriseProject)
+		writeResult(rw, "metrics", res, err)
+		return
+	}
+	// 资源不属于企业项目时返回空列表
+	dims, err := cesClient.filterDimsByEnterpriseProject(ctx, reqEnterpriseProject, reqNamespace, []string{reqDimStr})
+	if err != nil || len(dims) == 0 {
+		writeResult(rw, "metrics", []string{}, err)
+		return
+	}
+	writeResult(rw, "metrics", LoadMetrics(reqNamespace, reqDimStr), nil)
+}
+
+// purgeCache 管理员按region/namespace清理当前数据源的元数据缓存，参数为空表示不限
+func (ds *CloudEyeDatasource) purgeCache(rw http.ResponseWriter, req *http.Request) {
+	log.DefaultLogger.Info("Purge cache", "URL", req.URL.String())
+	if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		writeResult(rw, "", nil, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+	ctx := req.Context()
+	if user := httpadapter.UserFromContext(ctx); user == nil || user.Role != "Admin" {
+		writeResult(rw, "", nil, errors.New("only admin can purge cache"))
+		return
+	}
+	inst, err := ds.getInstance(ctx, httpadapter.PluginConfigFromContext(ctx))
+	if err != nil {
+		writeResult(rw, "", nil, err)
+		return
+	}
 
-	if cfg.MetaConfEnabled {
-		writeResult(rw, "metrics", LoadMetrics(params.Get("namespace"), params.Get("dimstr")), nil)
+	params, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		writeResult(rw, "", nil, err)
 		return
 	}
-	cesClient := &CESClient{Client: GetCESClient(cfg), Region: reqRegion}
-	res := cesClient.ListMetrics(params.Get("namespace"), params.Get("dimstr"))
-	writeResult(rw, "metrics", res, nil)
+	count := inst.caches.purge(params.Get("region"), params.Get("namespace"))
+	writeResult(rw, "purged", count, nil)
 }
 
+// instanceSettings 数据源实例，配置变更(版本更新)时由instance manager重新创建，
+// 实例内按region和目标账号复用CES客户端及连接
 type instanceSettings struct {
+	settings    *CloudEyeSettings
+	transport   *http.Transport
+	credentials CredentialProvider // 数据源自身的凭证
+	caches      *MetaCaches
+	mu          sync.Mutex
+	agencies    map[string]CredentialProvider // key: 委托的目标账号
+	clients     map[string]*cachedClient      // key: region|目标账号
+}
+
+// cachedClient 临时凭证刷新后需要使用新凭证重建客户端
+type cachedClient struct {
+	client *CESClient
+	cred   *Credential
 }
 
-func newCloudEyeInstance(_ backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &instanceSettings{}, nil
+func newCloudEyeInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	settings, err := loadSettings(setting)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newHttpTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := newCredentialProvider(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &instanceSettings{
+		settings:    settings,
+		transport:   transport,
+		credentials: credentials,
+		caches:      newMetaCaches(settings.CacheTTLs),
+		agencies:    make(map[string]CredentialProvider),
+		clients:     make(map[string]*cachedClient),
+	}, nil
+}
+
+// RegionEndpoint 单region模式下的一个region或子项目，Region为查询中使用的名称
+type RegionEndpoint struct {
+	Region      string `json:"region"`
+	ProjectID   string `json:"projectId"`
+	CESEndpoint string `json:"cesEndpoint"`
+}
+
+// specificRegions 返回单region模式配置的region/project列表，默认项在前；多region模式返回空
+func (c *CloudEyeSettings) specificRegions() []RegionEndpoint {
+	var entries []RegionEndpoint
+	isExist := make(map[string]bool)
+	if c.ProjectID != "" && c.CESEndpoint != "" {
+		entries = append(entries, RegionEndpoint{Region: c.Region, ProjectID: c.ProjectID, CESEndpoint: c.CESEndpoint})
+		isExist[c.Region] = true
+	}
+	for _, entry := range c.RegionEndpoints {
+		if entry.Region == "" || entry.ProjectID == "" || entry.CESEndpoint == "" || isExist[entry.Region] {
+			continue
+		}
+		entries = append(entries, entry)
+		isExist[entry.Region] = true
+	}
+	return entries
+}
+
+// defaultRegion 未配置Region时，使用Add Region/Project中的第一个region
+func (c *CloudEyeSettings) defaultRegion() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	for _, entry := range c.specificRegions() {
+		if entry.Region != "" {
+			return entry.Region
+		}
+	}
+	return ""
+}
+
+func (c *CloudEyeSettings) regionEndpoint(region string) (RegionEndpoint, bool) {
+	for _, entry := range c.specificRegions() {
+		if entry.Region == region {
+			return entry, true
+		}
+	}
+	return RegionEndpoint{}, false
+}
+
+func (ds *CloudEyeDatasource) getInstance(ctx context.Context, pluginCtx backend.PluginContext) (*instanceSettings, error) {
+	inst, err := ds.im.Get(ctx, pluginCtx)
+	if err != nil {
+		return nil, err
+	}
+	settings, ok := inst.(*instanceSettings)
+	if !ok {
+		return nil, errors.New("invalid datasource instance")
+	}
+	return settings, nil
+}
+
+// getCredentials 未指定目标账号时使用数据源配置的委托目标账号，均未配置时使用数据源自身的凭证
+func (s *instanceSettings) getCredentials(domain string) (CredentialProvider, error) {
+	if domain == "" {
+		domain = s.settings.AgencyDomain
+	}
+	if domain == "" {
+		return s.credentials, nil
+	}
+	if s.settings.AgencyName == "" {
+		return nil, errors.New("agency name is required for cross-account query")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	provider, ok := s.agencies[domain]
+	if !ok {
+		provider = newAgencyCredentialProvider(s.credentials, s.settings, domain, s.transport)
+		s.agencies[domain] = provider
+	}
+	return provider, nil
+}
+
+func (s *instanceSettings) getClient(region string) (*CESClient, error) {
+	return s.getAccountClient(region, "")
+}
+
+// getAccountClient 按region和目标账号懒加载CES客户端，凭证刷新后重建。
+// 构建客户端可能请求IAM，不持有锁，并发构建时保留先写入缓存的客户端
+func (s *instanceSettings) getAccountClient(region, domain string) (*CESClient, error) {
+	provider, err := s.getCredentials(domain)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := provider.Retrieve()
+	if err != nil {
+		return nil, fmt.Errorf("get credentials failed: %s", err.Error())
+	}
+
+	key := fmt.Sprintf("%s|%s", region, domain)
+	if cached := s.lookupClient(key, cred); cached != nil {
+		return cached, nil
+	}
+
+	cfg := *s.settings
+	cfg.AK, cfg.SK, cfg.SecurityToken = cred.AK, cred.SK, cred.SecurityToken
+	cesClient, err := buildCESClient(&cfg, region, s.transport)
+	if err != nil {
+		return nil, err
+	}
+	client := &CESClient{
+		Client:   cesClient,
+		Region:   region,
+		Domain:   domain,
+		Timeouts: s.settings.Timeouts,
+		Caches:   s.caches,
+		buildEPS: func() (*eps.EpsClient, error) {
+			return buildEPSClient(&cfg, s.transport)
+		},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.clients[key]; ok && cached.cred == cred {
+		return cached.client, nil
+	}
+	s.clients[key] = &cachedClient{client: client, cred: cred}
+	return client, nil
+}
+
+// lookupClient 返回使用当前凭证构建的缓存客户端，不存在时返回nil
+func (s *instanceSettings) lookupClient(key string, cred *Credential) *CESClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.clients[key]; ok && cached.cred == cred {
+		return cached.client
+	}
+	return nil
 }
 
 func (s *instanceSettings) Dispose() {
-	// Called before creating a a new instance to allow plugin authors
-	// to cleanup.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agencies = make(map[string]CredentialProvider)
+	s.clients = make(map[string]*cachedClient)
+	s.caches.reset()
+	s.transport.CloseIdleConnections()
 }
 
-func LoadSettings(ctx backend.PluginContext) (*CloudEyeSettings, error) {
+func loadSettings(setting backend.DataSourceInstanceSettings) (*CloudEyeSettings, error) {
 	var conf commonConf
-	setting := ctx.DataSourceInstanceSettings
 	err := json.Unmarshal(setting.JSONData, &conf)
 	if err != nil {
 		return nil, err
@@ -290,12 +683,40 @@ func LoadSettings(ctx backend.PluginContext) (*CloudEyeSettings, error) {
 
 	secDataMap := setting.DecryptedSecureJSONData
 	config := &CloudEyeSettings{
-		CESEndpoint:     conf.CESEndpoint,
-		Region:          conf.Region,
-		ProjectID:       conf.ProjectID,
-		MetaConfEnabled: conf.MetaConfEnabled,
-		AK:              secDataMap["accessKey"],
-		SK:              secDataMap["secretKey"],
+		CESEndpoint:         conf.CESEndpoint,
+		RegionEndpoints:     conf.RegionEndpoints,
+		Region:              conf.Region,
+		ProjectID:           conf.ProjectID,
+		MetaConfEnabled:     conf.MetaConfEnabled,
+		AK:                  secDataMap["accessKey"],
+		SK:                  secDataMap["secretKey"],
+		TLSSkipVerify:       conf.TLSSkipVerify,
+		TLSCACert:           secDataMap["tlsCACert"],
+		TLSClientCert:       secDataMap["tlsClientCert"],
+		TLSClientKey:        secDataMap["tlsClientKey"],
+		ProxyHost:           conf.ProxyHost,
+		ProxyPort:           conf.ProxyPort,
+		ProxyUsername:       secDataMap["proxyUsername"],
+		ProxyPassword:       secDataMap["proxyPassword"],
+		AuthType:            conf.AuthType,
+		SecurityToken:       secDataMap["securityToken"],
+		CredentialsFile:     conf.CredentialsFile,
+		CredentialsEndpoint: conf.CredentialsEndpoint,
+		IAMEndpoint:         conf.IAMEndpoint,
+		AgencyDomain:        conf.AgencyDomain,
+		AgencyName:          conf.AgencyName,
+		AgencyDuration:      conf.AgencyDuration,
+		EPSEndpoint:         conf.EPSEndpoint,
+		Timeouts:            newTimeouts(conf.QueryTimeout, conf.MetaTimeout, conf.CheckTimeout),
+		CacheTTLs:           newCacheTTLs(conf.NamespaceCacheTTL, conf.DimensionCacheTTL, conf.MetricCacheTTL),
+	}
+
+	// 多region模式的endpoint覆盖只需要region和endpoint
+	config.CESEndpoints = make(map[string]string, len(conf.CESEndpoints))
+	for _, entry := range conf.CESEndpoints {
+		if entry.Region != "" && entry.CESEndpoint != "" {
+			config.CESEndpoints[entry.Region] = entry.CESEndpoint
+		}
 	}
 
 	return config, nil
@@ -0,0 +1,243 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/basic"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/global"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/config"
+	ces "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
+	eps "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/eps/v1"
+	epsModel "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/eps/v1/model"
+)
+
+const (
+	defaultEPSEndpoint = "https://eps.myhuaweicloud.com"
+	epsPageLimit       = 1000
+)
+
+// epsResourceTypes namespace对应的企业项目资源类型，可通过metric.yaml的enterpriseProjectResourceTypes覆盖或扩展
+var epsResourceTypes = map[string][]string{
+	"SYS.ECS": {"ecs"},
+	"SYS.EVS": {"disk"},
+	"SYS.ELB": {"loadbalancers"},
+	"SYS.VPC": {"eip", "bandwidth"},
+	"SYS.RDS": {"rds"},
+	"SYS.DCS": {"dcs"},
+	"SYS.NAT": {"nat_gateways"},
+	"SYS.AS":  {"scaling_group"},
+}
+
+func getEpsResourceTypes(namespace string) []string {
+	if types, ok := GetMeta().EnterpriseProjectResourceTypes[namespace]; ok {
+		return types
+	}
+	return epsResourceTypes[namespace]
+}
+
+// buildEPSClient CES接口不支持按企业项目过滤，通过EPS查询企业项目下的资源
+func buildEPSClient(c *CloudEyeSettings, transport *http.Transport) (*eps.EpsClient, error) {
+	creBuilder := global.NewCredentialsBuilder().
+		WithAk(c.AK).
+		WithSk(c.SK)
+	if c.SecurityToken != "" {
+		creBuilder = creBuilder.WithSecurityToken(c.SecurityToken)
+	}
+	if c.IAMEndpoint != "" {
+		creBuilder = creBuilder.WithIamEndpointOverride(c.IAMEndpoint)
+	}
+	endpoint := c.EPSEndpoint
+	if endpoint == "" {
+		endpoint = defaultEPSEndpoint
+	}
+	cred, err := creBuilder.SafeBuild()
+	if err != nil {
+		return nil, err
+	}
+	hcClient, err := eps.EpsClientBuilder().
+		WithCredential(cred).
+		WithHttpConfig(config.DefaultHttpConfig().WithHttpTransport(transport)).
+		WithEndpoint(endpoint).
+		SafeBuild()
+	if err != nil {
+		return nil, err
+	}
+	return eps.NewEpsClient(hcClient), nil
+}
+
+// getEPSClient 仅在需要按企业项目过滤时构建EPS客户端
+func (c *CESClient) getEPSClient() (*eps.EpsClient, error) {
+	if c.buildEPS == nil {
+		return nil, errors.New("EPS client is not available")
+	}
+	c.epsOnce.Do(func() {
+		c.eps, c.epsErr = c.buildEPS()
+	})
+	return c.eps, c.epsErr
+}
+
+// getProjectID 多region模式下project id由SDK构建客户端时自动获取
+func getProjectID(client cesAPI) (string, error) {
+	cesClient, ok := client.(*ces.CesClient)
+	if !ok {
+		return "", errors.New("project id is unavailable for the CES client")
+	}
+	cred, ok := cesClient.HcClient.GetCredential().(*basic.Credentials)
+	if !ok || cred.ProjectId == "" {
+		return "", errors.New("project id is unavailable for the CES client")
+	}
+	return cred.ProjectId, nil
+}
+
+// enterpriseProjectResources 返回企业项目下namespace对应类型的资源ID
+func (c *CESClient) enterpriseProjectResources(ctx context.Context, epID, namespace string) (map[string]bool, error) {
+	// key: 目标账号|region|企业项目ID|namespace
+	key := fmt.Sprintf("%s|%s|%s|%s", c.Domain, c.Region, epID, namespace)
+	resourceTypes := getEpsResourceTypes(namespace)
+	if len(resourceTypes) == 0 {
+		return nil, fmt.Errorf("enterprise project filtering is not supported for namespace %s", namespace)
+	}
+	if cachedMeta := c.Caches.Ep.getCachedMeta(key); cachedMeta != nil {
+		// 过期后仍使用旧数据，在后台刷新；刷新失败时保留旧数据，间隔一段时间后再刷新
+		if cachedMeta.isExpired() {
+			c.Caches.Ep.flights.refresh(key, func(fetchCtx context.Context) ([]string, error) {
+				resourceIDs, err := c.fetchEnterpriseProjectResources(fetchCtx, c.Timeouts.max(), key, epID, resourceTypes)
+				if err != nil {
+					retry := *cachedMeta
+					retry.retryLater(false)
+					c.Caches.Ep.Data.Store(key, &retry)
+				}
+				return resourceIDs, err
+			})
+		}
+		return toSet(cachedMeta.Meta), nil
+	}
+
+	// 同一企业项目的并发请求只查询一次EPS
+	resourceIDs, err := c.Caches.Ep.flights.do(ctx, key, func(fetchCtx context.Context) ([]string, error) {
+		return c.fetchEnterpriseProjectResources(fetchCtx, c.Timeouts.Meta, key, epID, resourceTypes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toSet(resourceIDs), nil
+}
+
+func (c *CESClient) fetchEnterpriseProjectResources(ctx context.Context, timeout time.Duration, key, epID string,
+	resourceTypes []string) ([]string, error) {
+	epsClient, err := c.getEPSClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	projectID, err := getProjectID(c.Client)
+	if err != nil {
+		return nil, err
+	}
+	projects := []string{projectID}
+	limit := int32(epsPageLimit)
+	var offset int32
+	var resourceIDs []string
+	for {
+		req := &epsModel.ShowResourceBindEnterpriseProjectRequest{
+			EnterpriseProjectId: epID,
+			Body: &epsModel.ResqEpResouce{
+				Projects:      &projects,
+				ResourceTypes: resourceTypes,
+				Offset:        &offset,
+				Limit:         &limit,
+			},
+		}
+		res, err := callWithContext(ctx, func() (*epsModel.ShowResourceBindEnterpriseProjectResponse, error) {
+			return epsClient.ShowResourceBindEnterpriseProject(req)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list resources of enterprise project %s failed: %s", epID, err.Error())
+		}
+		if res.Resources == nil || len(*res.Resources) == 0 {
+			break
+		}
+		for _, resource := range *res.Resources {
+			resourceIDs = append(resourceIDs, resource.ResourceId)
+		}
+		offset += int32(len(*res.Resources))
+		if res.TotalCount != nil && offset >= *res.TotalCount {
+			break
+		}
+	}
+
+	c.Caches.Ep.Data.Store(key, &CachedMeta{
+		Meta:       resourceIDs,
+		Finished:   true,
+		TTL:        c.Caches.Ep.ttl,
+		UpdateTime: getTimestamp(),
+	})
+	return resourceIDs, nil
+}
+
+func toSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, v := range list {
+		set[v] = true
+	}
+	return set
+}
+
+// inEnterpriseProject 维度取值中任一资源ID属于企业项目即认为该资源属于企业项目
+func inEnterpriseProject(dimStr string, resourceIDs map[string]bool) bool {
+	for _, dim := range strings.Split(dimStr, ",") {
+		eachDim := strings.Split(dim, ":")
+		if len(eachDim) == 2 && resourceIDs[eachDim[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDimsByEnterpriseProject 过滤掉不属于企业项目的资源
+func (c *CESClient) filterDimsByEnterpriseProject(ctx context.Context, epID, namespace string, dims []string) ([]string, error) {
+	resourceIDs, err := c.enterpriseProjectResources(ctx, epID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(dims))
+	for _, dimStr := range dims {
+		if inEnterpriseProject(dimStr, resourceIDs) {
+			res = append(res, dimStr)
+		}
+	}
+	return res, nil
+}
+
+// filterBatchByEnterpriseProject 指定企业项目的查询只返回企业项目内资源的数据，其余查询返回错误
+func (c *CESClient) filterBatchByEnterpriseProject(ctx context.Context, epID string, refIDs []string, req *model.BatchListMetricDataRequest,
+	response *backend.QueryDataResponse) ([]string, *model.BatchListMetricDataRequest) {
+	if req == nil || req.Body == nil || len(refIDs) != len(req.Body.Metrics) {
+		return refIDs, req
+	}
+	body := *req.Body
+	body.Metrics = nil
+	var filteredRefIDs []string
+	for i, metric := range req.Body.Metrics {
+		resourceIDs, err := c.enterpriseProjectResources(ctx, epID, metric.Namespace)
+		if err == nil && !inEnterpriseProject(getDimStr(metric.Dimensions), resourceIDs) {
+			err = fmt.Errorf("resource %s is not in enterprise project %s", getDimStr(metric.Dimensions), epID)
+		}
+		if err != nil {
+			mergeDataResponse(response, refIDs[i], backend.DataResponse{Error: err})
+			continue
+		}
+		filteredRefIDs = append(filteredRefIDs, refIDs[i])
+		body.Metrics = append(body.Metrics, metric)
+	}
+	return filteredRefIDs, &model.BatchListMetricDataRequest{Body: &body}
+}
@@ -0,0 +1,297 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/ces/v1/model"
+)
+
+const (
+	defaultFilter = "average"
+	defaultPeriod = "1"
+)
+
+var validFilters = map[string]bool{"average": true, "min": true, "max": true, "sum": true, "variance": true}
+
+const autoPeriod = "auto"
+
+// cesPeriods CES支持的聚合周期，由小到大排列；原始粒度(1)按60s上报间隔估算数据点数
+var cesPeriods = []struct {
+	Value   string
+	Seconds int64
+}{
+	{"1", 60},
+	{"300", 300},
+	{"1200", 1200},
+	{"3600", 3600},
+	{"14400", 14400},
+	{"86400", 86400},
+}
+
+// 匹配$var、${var}、[[var]]形式的模板变量
+var templateVarPattern = regexp.MustCompile(`\$\{?\w+\}?|\[\[\w+\]\]`)
+
+// batchQuery 同一region/目标账号/企业项目/filter/period/时间范围/frame选项的查询合并为一次BatchListMetricData请求
+type batchQuery struct {
+	Region              string
+	AgencyDomain        string
+	EnterpriseProjectID string
+	RefIDs              []string
+	Req                 *model.BatchListMetricDataRequest
+	Options             FrameOptions
+}
+
+// parseDataQuery 解析查询参数，告警等后端执行场景下没有浏览器解析模板变量，
+// 未指定的region/filter/period使用默认值，period为auto时按时间范围自动选择，仍包含模板变量的查询直接报错
+func parseDataQuery(query backend.DataQuery, defaultRegion string) (*DataQueryParam, error) {
+	var param DataQueryParam
+	if err := json.Unmarshal(query.JSON, &param); err != nil {
+		return nil, fmt.Errorf("parse query error: %s", err.Error())
+	}
+	param.setDefaults(defaultRegion)
+	param.RefID = query.RefID
+	param.From = query.TimeRange.From.UnixNano() / 1e6
+	param.To = query.TimeRange.To.UnixNano() / 1e6
+	if param.Period == autoPeriod {
+		param.Period = selectPeriod(query.Interval, query.MaxDataPoints, param.From, param.To)
+	}
+	if err := param.validate(); err != nil {
+		return nil, err
+	}
+	return &param, nil
+}
+
+func (p *DataQueryParam) setDefaults(defaultRegion string) {
+	if p.Region == "" || p.Region == "-" {
+		p.Region = defaultRegion
+	}
+	if p.Filter == "" {
+		p.Filter = defaultFilter
+	}
+	if p.Period == "" {
+		p.Period = defaultPeriod
+	}
+}
+
+func (p *DataQueryParam) validate() error {
+	fields := []struct {
+		name     string
+		value    string
+		optional bool
+	}{
+		{name: "region", value: p.Region},
+		{name: "namespace", value: p.Namespace},
+		{name: "dimstr", value: p.DimStr},
+		{name: "metricName", value: p.MetricName},
+		{name: "filter", value: p.Filter},
+		{name: "period", value: p.Period},
+		{name: "agencyDomain", value: p.AgencyDomain, optional: true},
+		{name: "enterpriseProjectId", value: p.EnterpriseProjectID, optional: true},
+	}
+	for _, field := range fields {
+		if field.value == "" && !field.optional {
+			return fmt.Errorf("%s is required", field.name)
+		}
+		if v := templateVarPattern.FindString(field.value); v != "" {
+			return fmt.Errorf("unresolved template variable %s in %s, use a concrete value for backend queries", v, field.name)
+		}
+	}
+	for _, filter := range p.filters() {
+		if v := templateVarPattern.FindString(filter); v != "" {
+			return fmt.Errorf("unresolved template variable %s in stats, use a concrete value for backend queries", v)
+		}
+		if !validFilters[filter] {
+			return fmt.Errorf("unsupported filter: %s", filter)
+		}
+	}
+	if !isValidPeriod(p.Period) {
+		return fmt.Errorf("unsupported period: %s", p.Period)
+	}
+	if p.From >= p.To {
+		return errors.New("from must be earlier than to")
+	}
+	return nil
+}
+
+// reportInterval 相邻数据点的间隔，原始粒度按60s上报间隔计算
+func reportInterval(period string) time.Duration {
+	for _, p := range cesPeriods {
+		if p.Value == period {
+			return time.Duration(p.Seconds) * time.Second
+		}
+	}
+	return 0
+}
+
+func isValidPeriod(period string) bool {
+	for _, p := range cesPeriods {
+		if p.Value == period {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPeriod 选择不小于interval且数据点数不超过maxDataPoints的最小周期
+func selectPeriod(interval time.Duration, maxDataPoints int64, from, to int64) string {
+	rangeSeconds := (to - from) / 1000
+	for _, p := range cesPeriods {
+		if int64(interval/time.Second) > p.Seconds {
+			continue
+		}
+		if maxDataPoints > 0 && rangeSeconds/p.Seconds > maxDataPoints {
+			continue
+		}
+		return p.Value
+	}
+	return cesPeriods[len(cesPeriods)-1].Value
+}
+
+// filters 返回需要查询的统计值，每个统计值对应一次批量查询
+func (p *DataQueryParam) filters() []string {
+	if len(p.Stats) == 0 {
+		return []string{p.Filter}
+	}
+	isExist := make(map[string]bool, len(p.Stats))
+	filters := make([]string, 0, len(p.Stats))
+	for _, stat := range p.Stats {
+		if !isExist[stat] {
+			isExist[stat] = true
+			filters = append(filters, stat)
+		}
+	}
+	return filters
+}
+
+func (p *DataQueryParam) frameOptions() FrameOptions {
+	return FrameOptions{FillGaps: p.FillGaps, AlignPeriod: p.AlignPeriod, StatLabel: len(p.Stats) > 0}
+}
+
+func parseDimStr(dimStr string) []model.MetricsDimension {
+	var dims []model.MetricsDimension
+	for _, dim := range strings.Split(dimStr, ",") {
+		eachDim := strings.Split(dim, ":")
+		if len(eachDim) != 2 {
+			continue
+		}
+		dims = append(dims, model.MetricsDimension{Name: eachDim[0], Value: eachDim[1]})
+	}
+	return dims
+}
+
+// buildBatchQueries 解析并聚合查询，解析失败的查询直接在response中返回错误
+func buildBatchQueries(queries []backend.DataQuery, defaultRegion string, response *backend.QueryDataResponse) []*batchQuery {
+	batchMap := make(map[string]*batchQuery)
+	var batches []*batchQuery
+	for _, query := range queries {
+		param, err := parseDataQuery(query, defaultRegion)
+		if err != nil {
+			response.Responses[query.RefID] = backend.DataResponse{Error: err}
+			continue
+		}
+
+		for _, filter := range param.filters() {
+			opts := param.frameOptions()
+			key := fmt.Sprintf("%s|%s|%s|%s|%s|%d|%d|%+v", param.Region, param.AgencyDomain, param.EnterpriseProjectID,
+				filter, param.Period, param.From, param.To, opts)
+			batch, ok := batchMap[key]
+			if !ok {
+				batch = &batchQuery{
+					Region:              param.Region,
+					AgencyDomain:        param.AgencyDomain,
+					EnterpriseProjectID: param.EnterpriseProjectID,
+					Options:             opts,
+					Req: &model.BatchListMetricDataRequest{
+						Body: &model.BatchListMetricDataRequestBody{
+							Filter: filter,
+							Period: param.Period,
+							From:   param.From,
+							To:     param.To,
+						},
+					},
+				}
+				batchMap[key] = batch
+				batches = append(batches, batch)
+			}
+			batch.RefIDs = append(batch.RefIDs, param.RefID)
+			batch.Req.Body.Metrics = append(batch.Req.Body.Metrics, model.MetricInfo{
+				Namespace:  param.Namespace,
+				MetricName: param.MetricName,
+				Dimensions: parseDimStr(param.DimStr),
+			})
+		}
+	}
+	return batches
+}
+
+// mergeDataResponse 多统计值查询时同一refID有多个批量查询结果，按时间合并为一个frame，任一失败则返回错误
+func mergeDataResponse(response *backend.QueryDataResponse, refID string, res backend.DataResponse) {
+	existing, ok := response.Responses[refID]
+	if !ok || res.Error != nil {
+		response.Responses[refID] = res
+		return
+	}
+	if existing.Error != nil {
+		return
+	}
+	if len(existing.Frames) == 1 && len(res.Frames) == 1 && isTimeSeriesFrame(existing.Frames[0]) && isTimeSeriesFrame(res.Frames[0]) {
+		existing.Frames = data.Frames{joinFrames(existing.Frames[0], res.Frames[0])}
+	} else {
+		existing.Frames = append(existing.Frames, res.Frames...)
+	}
+	response.Responses[refID] = existing
+}
+
+func isTimeSeriesFrame(frame *data.Frame) bool {
+	return len(frame.Fields) >= 2 && frame.Fields[0].Type() == data.FieldTypeTime
+}
+
+// joinFrames 合并同一指标不同统计值的frame，共用时间字段，每个统计值一个数值字段，缺失的点为空值
+func joinFrames(a, b *data.Frame) *data.Frame {
+	index := make(map[int64]int)
+	var times []time.Time
+	for _, frame := range []*data.Frame{a, b} {
+		for i := 0; i < frame.Fields[0].Len(); i++ {
+			t, _ := frame.Fields[0].At(i).(time.Time)
+			if _, ok := index[t.UnixMilli()]; !ok {
+				index[t.UnixMilli()] = len(times)
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i, t := range times {
+		index[t.UnixMilli()] = i
+	}
+
+	fields := []*data.Field{data.NewField(a.Fields[0].Name, nil, times)}
+	var notices []data.Notice
+	for _, frame := range []*data.Frame{a, b} {
+		for _, valueField := range frame.Fields[1:] {
+			values := make([]*float64, len(times))
+			for i := 0; i < valueField.Len(); i++ {
+				t, _ := frame.Fields[0].At(i).(time.Time)
+				values[index[t.UnixMilli()]], _ = valueField.At(i).(*float64)
+			}
+			field := data.NewField(valueField.Name, valueField.Labels, values)
+			field.Config = valueField.Config
+			fields = append(fields, field)
+		}
+		if frame.Meta != nil {
+			notices = append(notices, frame.Meta.Notices...)
+		}
+	}
+	frame := data.NewFrame(a.Name, fields...)
+	if len(notices) > 0 {
+		frame.SetMeta(&data.FrameMeta{Notices: notices})
+	}
+	return frame
+}
@@ -1,7 +1,7 @@
 import {defaults} from 'lodash';
 
-import React, {PureComponent} from 'react';
-import {InlineFormLabel, SegmentAsync, Select} from '@grafana/ui';
+import React, {ChangeEvent, PureComponent} from 'react';
+import {InlineFormLabel, InlineSwitch, Input, MultiSelect, SegmentAsync, Select} from '@grafana/ui';
 import {QueryEditorProps} from '@grafana/data';
 import {DataSource} from './datasource';
 import {defaultQuery, MyDataSourceOptions, MyQuery} from './types';
@@ -22,12 +22,40 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onStatsChange = (items: any[]) => {
+    const {onChange, onRunQuery, query} = this.props;
+    onChange({...query, stats: items.map((item: any) => item.value)});
+    onRunQuery();
+  };
+
   onPeriodChange = (item: any) => {
     const {onChange, onRunQuery, query} = this.props;
     onChange({...query, period: item.value});
     onRunQuery();
   };
 
+  onFillGapsChange = () => {
+    const {onChange, onRunQuery, query} = this.props;
+    onChange({...query, fillGaps: !query.fillGaps});
+    onRunQuery();
+  };
+
+  onAlignPeriodChange = () => {
+    const {onChange, onRunQuery, query} = this.props;
+    onChange({...query, alignPeriod: !query.alignPeriod});
+    onRunQuery();
+  };
+
+  onAgencyDomainChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const {onChange, query} = this.props;
+    onChange({...query, agencyDomain: event.target.value});
+  };
+
+  onEnterpriseProjectChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const {onChange, query} = this.props;
+    onChange({...query, enterpriseProjectId: event.target.value});
+  };
+
   onRegionChange = (item: any) => {
     const {onChange, query} = this.props;
     onChange({...query, region: item.value});
@@ -57,6 +85,28 @@ export class QueryEditor extends PureComponent<Props> {
     return (
       <div className="gf-form">
         <div className="gf-form-inline">
+          <InlineFormLabel width={5} tooltip={<p>跨账号监控的目标账号，为空时使用数据源配置</p>}>
+            account
+          </InlineFormLabel>
+          <Input
+            width={15}
+            placeholder="agency domain"
+            value={query.agencyDomain || ''}
+            onChange={this.onAgencyDomainChange}
+            onBlur={this.props.onRunQuery}
+          />
+
+          <InlineFormLabel width={5} tooltip={<p>企业项目ID，只选择和查询该企业项目内的资源</p>}>
+            enterprise project
+          </InlineFormLabel>
+          <Input
+            width={15}
+            placeholder="enterprise project id"
+            value={query.enterpriseProjectId || ''}
+            onChange={this.onEnterpriseProjectChange}
+            onBlur={this.props.onRunQuery}
+          />
+
           <InlineFormLabel width={5} tooltip={<p>Select Region</p>}>
             Region
           </InlineFormLabel>
@@ -72,7 +122,7 @@ export class QueryEditor extends PureComponent<Props> {
             Namespace
           </InlineFormLabel>
           <SegmentAsync
-            loadOptions={() => datasource.listNamespaces(query.region)}
+            loadOptions={() => datasource.listNamespaces(query.region, query.agencyDomain)}
             placeholder="namespace"
             value={query.namespace}
             allowCustomValue={false}
@@ -83,7 +133,7 @@ export class QueryEditor extends PureComponent<Props> {
             dimstr
           </InlineFormLabel>
           <SegmentAsync
-            loadOptions={() => datasource.listDims(query.region, query.namespace, '', '')}
+            loadOptions={() => datasource.listDims(query.region, query.namespace, '', '', query.agencyDomain, query.enterpriseProjectId)}
             placeholder="dimstr"
             value={query.dimstr}
             allowCustomValue={false}
@@ -94,7 +144,7 @@ export class QueryEditor extends PureComponent<Props> {
             metrics
           </InlineFormLabel>
           <SegmentAsync
-            loadOptions={() => datasource.listMetrics(query.region, query.namespace, query.dimstr)}
+            loadOptions={() => datasource.listMetrics(query.region, query.namespace, query.dimstr, query.agencyDomain, query.enterpriseProjectId)}
             placeholder="metrics"
             value={query.metricName}
             allowCustomValue={false}
@@ -113,6 +163,17 @@ export class QueryEditor extends PureComponent<Props> {
             onChange={this.onFilterChange}
           />
 
+          <InlineFormLabel width={5} tooltip={<p>同时查询多个统计值，选择后忽略filter</p>}>
+            stats
+          </InlineFormLabel>
+          <MultiSelect
+            width={30}
+            options={datasource.listFilterOptions()}
+            placeholder="stats"
+            value={query.stats || []}
+            onChange={this.onStatsChange}
+          />
+
           <InlineFormLabel width={5} tooltip={<p>period</p>}>
             period
           </InlineFormLabel>
@@ -124,6 +185,26 @@ export class QueryEditor extends PureComponent<Props> {
             allowCustomValue={false}
             onChange={this.onPeriodChange}
           />
+
+          <InlineFormLabel width={5} tooltip={<p>按period补充缺失的数据点，图表在缺失处断开</p>}>
+            fill gaps
+          </InlineFormLabel>
+          <InlineSwitch
+            value={query.fillGaps || false}
+            onChange={this.onFillGapsChange}
+            onPointerEnterCapture={null}
+            onPointerLeaveCapture={null}
+          />
+
+          <InlineFormLabel width={5} tooltip={<p>数据点时间对齐到period边界，便于不同指标对齐计算</p>}>
+            align
+          </InlineFormLabel>
+          <InlineSwitch
+            value={query.alignPeriod || false}
+            onChange={this.onAlignPeriodChange}
+            onPointerEnterCapture={null}
+            onPointerLeaveCapture={null}
+          />
         </div>
       </div>
     );
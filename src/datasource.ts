@@ -4,9 +4,11 @@ import {
   DataSourceInstanceSettings,
   FieldType,
   MutableDataFrame,
+  ScopedVars,
   SelectableValue
 } from '@grafana/data';
 import {DataSourceWithBackend, getTemplateSrv} from '@grafana/runtime';
+import {lastValueFrom} from 'rxjs';
 import {MyDataSourceOptions, MyQuery} from './types';
 
 
@@ -17,6 +19,9 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     if (response && response.data && response.data.results) {
       for (let ref in response.data.results) {
         if (Object.prototype.hasOwnProperty.call(response.data.results, ref)) {
+          if (response.data.results[ref].error) {
+            return {refId: ref, error: response.data.results[ref].error};
+          }
           const label: any = {
             namespace: target.namespace
           }
@@ -27,7 +32,7 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
             refId: ref,
             fields: [
               {name: "Time", type: FieldType.time},
-              {name: metric.metric_name, type: FieldType.number, labels: label},
+              {name: metric.metric_name, type: FieldType.number, labels: label, config: this.getFieldConfig(response.data.results[ref])},
             ],
           });
 
@@ -49,13 +54,39 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
 
   // @ts-ignore
   async query(options: DataQueryRequest<MyQuery>): Promise<DataQueryResponse> {
+    // 多统计值查询由后端QueryData执行
+    if (options.targets.some((target: MyQuery) => target.stats && target.stats.length > 0)) {
+      return lastValueFrom(super.query(options));
+    }
     if (!this.variableIsExist('filter') || !this.variableIsExist('period')) {
       const promises = this.listMetricDataByCustom(options);
-      return Promise.all(promises).then((data: any) => ({data}))
+      return Promise.all(promises).then((data: any) => this.buildQueryResponse(data))
     }
     const promise = this.listMetricDataByTemplate(options);
     // @ts-ignore
-    return promise.then((data: any) => ({data}));
+    return promise.then((data: any) => this.buildQueryResponse(data));
+  }
+
+  // 后端按指标目录设置的单位、展示名等配置
+  getFieldConfig(result: any): any {
+    const fields = result.frames[0].schema.fields;
+    return fields.length > 1 && fields[1].config ? fields[1].config : {};
+  }
+
+  // 单个查询失败时只跳过对应的结果，错误信息通过DataQueryResponse.error展示
+  buildQueryResponse(items: any[]): DataQueryResponse {
+    const data = items.filter((item: any) => item && !item.error);
+    const errors = items.filter((item: any) => item && item.error);
+    if (errors.length === 0) {
+      return {data};
+    }
+    return {
+      data,
+      error: {
+        refId: errors[0].refId,
+        message: errors.map((item: any) => item.refId + ': ' + item.error).join('; ')
+      }
+    };
   }
 
   // 不使用模板，使用自定义dashboard的场景查询监控数据
@@ -76,7 +107,13 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
         to: options.range.to.valueOf(),
         filter: target.filter || 'average',
         period: target.period || '1',
-        region: target.region || 'cn-east-3'
+        region: target.region || 'cn-east-3',
+        fillGaps: target.fillGaps || false,
+        alignPeriod: target.alignPeriod || false,
+        agencyDomain: target.agencyDomain || '',
+        enterpriseProjectId: getTemplateSrv().replace(target.enterpriseProjectId || ''),
+        maxDataPoints: options.maxDataPoints,
+        intervalMs: options.intervalMs
       }
       // @ts-ignore
       return this.listMetricData(reqBody).then(response => {
@@ -86,10 +123,9 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     return promises;
   }
 
-  // 使用模板生成dashboard场景查询监控数据
+  // 使用模板生成dashboard场景查询监控数据，补点、对齐选项按查询设置，选项相同的查询合并为一个请求
   listMetricDataByTemplate(options: any) {
-    const metrics: Array<any> = [];
-    const refIDs: Array<any> = [];
+    const groups: any = {};
     const queriesMap: any = {};
     // @ts-ignore
     options.targets.forEach(target => {
@@ -100,55 +136,92 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
       metric.namespace = target.namespace;
       metric.dimensions = this.handleDimStr(target);
       metric.metric_name = target.metricName;
-      metrics.push(metric);
-      refIDs.push(target.refId);
+      const fillGaps = target.fillGaps || false;
+      const alignPeriod = target.alignPeriod || false;
+      const key = `${fillGaps}|${alignPeriod}`;
+      if (!groups[key]) {
+        groups[key] = {metrics: [], refIDs: [], fillGaps, alignPeriod};
+      }
+      groups[key].metrics.push(metric);
+      groups[key].refIDs.push(target.refId);
       queriesMap[target.refId] = metric;
     });
-    const reqBody = {
-      metrics,
-      refIDs,
-      from: options.range.from.valueOf(),
-      to: options.range.to.valueOf(),
-      filter: this.getVarValue('filter', 'average'),
-      period: this.getVarValue('period', '1'),
-      region: this.getVarValue('region', 'cn-east-3')
-    };
-    if (metrics.length === 0 || refIDs.length === 0) {
-      return new Promise(resolve => resolve).then(() => {
-        return {data: []}
-      })
+    const keys = Object.keys(groups);
+    if (keys.length === 0) {
+      return Promise.resolve([]);
     }
-    const promise = this.listMetricData(reqBody).then(response => {
-      const frames: Array<any> = [];
-      if (response && response.data && response.data.results) {
-        for (let ref in response.data.results) {
-          if (Object.prototype.hasOwnProperty.call(response.data.results, ref)) {
-            const label: any = {
-              namespace: queriesMap[ref].namespace
-            }
-            queriesMap[ref].dimensions.forEach((dim: any) => {
-              label[dim.name] = dim.value;
-            });
-            const frame = new MutableDataFrame({
-              refId: ref,
-              fields: [
-                {name: "Time", type: FieldType.time},
-                {name: queriesMap[ref].metric_name, type: FieldType.number, labels: label},
-              ],
-            });
-
-            const times = response.data.results[ref].frames[0].data.values[0];
-            const values = response.data.results[ref].frames[0].data.values[1];
-            times.forEach((time: any, index: any) => {
-              frame.appendRow([time, values[index]]);
-            });
-            frames.push(frame);
+    const promises = keys.map(key => {
+      const reqBody = {
+        metrics: groups[key].metrics,
+        refIDs: groups[key].refIDs,
+        from: options.range.from.valueOf(),
+        to: options.range.to.valueOf(),
+        filter: this.getVarValue('filter', 'average'),
+        period: this.getVarValue('period', '1'),
+        region: this.getVarValue('region', 'cn-east-3'),
+        fillGaps: groups[key].fillGaps,
+        alignPeriod: groups[key].alignPeriod,
+        enterpriseProjectId: this.getVarValue('enterpriseProjectId', ''),
+        maxDataPoints: options.maxDataPoints,
+        intervalMs: options.intervalMs
+      };
+      return this.listMetricData(reqBody).then(response => this.processTemplateResponse(response, queriesMap));
+    });
+    return Promise.all(promises).then((results: any) => [].concat(...results));
+  }
+
+  processTemplateResponse(response: any, queriesMap: any) {
+    const frames: Array<any> = [];
+    if (response && response.data && response.data.results) {
+      for (let ref in response.data.results) {
+        if (Object.prototype.hasOwnProperty.call(response.data.results, ref)) {
+          if (response.data.results[ref].error) {
+            frames.push({refId: ref, error: response.data.results[ref].error});
+            continue;
           }
+          const label: any = {
+            namespace: queriesMap[ref].namespace
+          }
+          queriesMap[ref].dimensions.forEach((dim: any) => {
+            label[dim.name] = dim.value;
+          });
+          const frame = new MutableDataFrame({
+            refId: ref,
+            fields: [
+              {name: "Time", type: FieldType.time},
+              {name: queriesMap[ref].metric_name, type: FieldType.number, labels: label, config: this.getFieldConfig(response.data.results[ref])},
+            ],
+          });
+
+          const times = response.data.results[ref].frames[0].data.values[0];
+          const values = response.data.results[ref].frames[0].data.values[1];
+          times.forEach((time: any, index: any) => {
+            frame.appendRow([time, values[index]]);
+          });
+          frames.push(frame);
         }
       }
-      return frames;
-    });
-    return promise;
+    }
+    return frames;
+  }
+
+  // 后端执行查询(告警规则等)时无法读取dashboard变量，提前将模板变量解析为具体取值
+  applyTemplateVariables(query: MyQuery, scopedVars: ScopedVars): MyQuery {
+    const templateSrv = getTemplateSrv();
+    const dimstr = this.handleDimStr(query).map((dim: any) => dim.name + ':' + dim.value).join(',');
+    return {
+      ...query,
+      region: this.variableIsExist('region') ? this.getVarValue('region', query.region || '') : templateSrv.replace(query.region, scopedVars),
+      dimstr: templateSrv.replace(dimstr, scopedVars),
+      filter: this.variableIsExist('filter') ? this.getVarValue('filter', 'average') : query.filter,
+      period: this.variableIsExist('period') ? this.getVarValue('period', '1') : query.period,
+      agencyDomain: templateSrv.replace(query.agencyDomain, scopedVars),
+      enterpriseProjectId: templateSrv.replace(query.enterpriseProjectId, scopedVars),
+    };
+  }
+
+  interpolateVariablesInQueries(queries: MyQuery[], scopedVars: ScopedVars): MyQuery[] {
+    return queries.map(query => this.applyTemplateVariables(query, scopedVars));
   }
 
   // @ts-ignore
@@ -172,6 +245,7 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
       const namespace = listDimsParams[1] ? listDimsParams[1] : '';
       let dimsName = listDimsParams[2] ? listDimsParams[2] : '';
       const tagDimName = listDimsParams[3] ? listDimsParams[3] : '';
+      const enterpriseProjectId = listDimsParams[4] ? getTemplateSrv().replace(listDimsParams[4]) : '';
       let region = '';
       templateVariables.forEach((item: any) => {
         if (regionVar.indexOf("$" + item.name) >= 0) {
@@ -182,7 +256,7 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
         }
         dimsName = dimsName.replace(";",",");
       });
-      return await this.listDims(region, namespace, dimsName, tagDimName);
+      return await this.listDims(region, namespace, dimsName, tagDimName, undefined, enterpriseProjectId);
     }
   }
 
@@ -192,11 +266,13 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
       {text: '最小值', label: '最小值', value: 'min'},
       {text: '最大值', label: '最大值', value: 'max'},
       {text: '求和值', label: '求和值', value: 'sum'},
+      {text: '方差', label: '方差', value: 'variance'},
     ];
   }
 
   listPeriodOptions(): any[] {
     return [
+      {text: '自动粒度', label: '自动粒度', value: 'auto'},
       {text: '原始粒度', label: '原始粒度', value: '1'},
       {text: '5min粒度', label: '5min粒度', value: '300'},
       {text: '1h粒度', label: '1h粒度', value: '3600'},
@@ -209,14 +285,14 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     });
   }
 
-  async listNamespaces(region: string | undefined): Promise<Array<SelectableValue<string>>> {
-    return this.getResource('namespaces', {region: region}).then(({namespaces}) => {
+  async listNamespaces(region: string | undefined, agencyDomain?: string): Promise<Array<SelectableValue<string>>> {
+    return this.getResource('namespaces', {region: region, agencyDomain: agencyDomain || ''}).then(({namespaces}) => {
       return namespaces ? namespaces.map((item: string) => ({text: item, label: item, value: item})) : [];
     });
   }
 
-  async listDims(region: string | undefined, namespace: string | undefined, dimsName: string, tagDimName: string): Promise<Array<SelectableValue<string>>> {
-    return this.getResource('dimensions', {region: region, namespace: namespace}).then(({dimensions}) => {
+  async listDims(region: string | undefined, namespace: string | undefined, dimsName: string, tagDimName: string, agencyDomain?: string, enterpriseProjectId?: string): Promise<Array<SelectableValue<string>>> {
+    return this.getResource('dimensions', {region: region, namespace: namespace, agencyDomain: agencyDomain || '', enterpriseProjectId: enterpriseProjectId || ''}).then(({dimensions}) => {
       const dims = Object.values(dimensions);
       const result: Array<SelectableValue<string>> = [];
       if (dimsName === '') {
@@ -269,8 +345,8 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
   }
 
 
-  async listMetrics(region: string | undefined, namespace: string | undefined, dimstr: string | undefined): Promise<Array<SelectableValue<string>>> {
-    return this.getResource('metrics', {region: region, namespace: namespace, dimstr: dimstr}).then(({metrics}) => {
+  async listMetrics(region: string | undefined, namespace: string | undefined, dimstr: string | undefined, agencyDomain?: string, enterpriseProjectId?: string): Promise<Array<SelectableValue<string>>> {
+    return this.getResource('metrics', {region: region, namespace: namespace, dimstr: dimstr, agencyDomain: agencyDomain || '', enterpriseProjectId: enterpriseProjectId || ''}).then(({metrics}) => {
       return metrics ? metrics.map((item: string) => ({label: item, value: item})) : [];
     });
   }
@@ -7,6 +7,11 @@ export interface MyQuery extends DataQuery {
   metricName?: string;
   filter?: string;
   period?: string;
+  stats?: string[];
+  fillGaps?: boolean;
+  alignPeriod?: boolean;
+  agencyDomain?: string;
+  enterpriseProjectId?: string;
   from?: number;
   to?: number;
 }
@@ -20,6 +25,12 @@ export const defaultQuery: Partial<MyQuery> = {
   period: "1",
 };
 
+export interface RegionEndpoint {
+  region?: string;
+  projectId?: string;
+  cesEndpoint?: string;
+}
+
 /**
  * These are options configured for each DataSource instance.
  */
@@ -28,7 +39,25 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
   cesEndpoint?: string;
   region?: string;
   projectId?: string;
+  regionEndpoints?: RegionEndpoint[];
+  cesEndpoints?: RegionEndpoint[];
   metaConfEnabled?: boolean;
+  tlsSkipVerify?: boolean;
+  proxyHost?: string;
+  proxyPort?: number;
+  authType?: string;
+  credentialsFile?: string;
+  credentialsEndpoint?: string;
+  agencyDomain?: string;
+  agencyName?: string;
+  agencyDuration?: number;
+  epsEndpoint?: string;
+  queryTimeout?: number;
+  metaTimeout?: number;
+  checkTimeout?: number;
+  namespaceCacheTTL?: number;
+  dimensionCacheTTL?: number;
+  metricCacheTTL?: number;
 }
 
 /**
@@ -37,4 +66,10 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
 export interface MySecureJsonData {
   accessKey?: string;
   secretKey?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
+  proxyUsername?: string;
+  proxyPassword?: string;
+  securityToken?: string;
 }